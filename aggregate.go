@@ -0,0 +1,262 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository"
+	"github.com/ace-zhaoy/go-repository/contract"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+)
+
+// CountDistinct counts the distinct values of field among the matched documents, as len(Distinct(...)) would:
+// the elements of an array are counted as values, and documents missing the field or with an empty array are not.
+func (c *CrudRepository[ID, ENTITY]) CountDistinct(ctx context.Context, field string, filter map[string]any) (count int, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", field, filter) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{
+			c.buildFilter(filter),
+			bson.M{field: bson.M{"$exists": true}},
+		}}}},
+		// nulls are preserved, as Distinct returns them, and so are empty arrays, which lose the field and are
+		// filtered out again
+		{{Key: "$unwind", Value: bson.M{"path": "$" + field, "preserveNullAndEmptyArrays": true}}},
+		{{Key: "$match", Value: bson.M{field: bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + field}}},
+		{{Key: "$count", Value: "count"}},
+	}
+	cursor, err := c.collection.Aggregate(ctx, pipeline, c.newAggregateOptions())
+	errors.Check(mapError(err))
+
+	var results []struct {
+		Count int `bson:"count"`
+	}
+	err = cursor.All(ctx, &results)
+	errors.Check(mapError(err))
+	if len(results) > 0 {
+		count = results[0].Count
+	}
+	return
+}
+
+// Distinct returns the distinct values of field among the matched documents that are not soft-deleted.
+func (c *CrudRepository[ID, ENTITY]) Distinct(ctx context.Context, field string, filter map[string]any) (values []any, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", field, filter) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	values, err = c.collection.Distinct(ctx, field, c.buildFilter(filter))
+	errors.Check(mapError(err))
+	return
+}
+
+// DistinctUnscoped is like Distinct, including the values of soft-deleted documents.
+func (c *CrudRepository[ID, ENTITY]) DistinctUnscoped(ctx context.Context, field string, filter map[string]any) (values []any, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", field, filter) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	values, err = c.collection.Distinct(ctx, field, c.buildScopedFilter(filter, nil))
+	errors.Check(mapError(err))
+	return
+}
+
+// AggregateFind is like FindByFilterWithPage, for sorting on fields computed by addFields,
+// e.g. bson.M{"tag_count": bson.M{"$size": "$tags"}}. Computed fields missing from ENTITY are dropped on decoding.
+// A zero limit means no limit. The WithDefaultProjection and WithArraySlice projection applies after the limit,
+// so the sort may use fields it leaves out.
+func (c *CrudRepository[ID, ENTITY]) AggregateFind(ctx context.Context, matchFilter map[string]any, addFields bson.M, sort bson.D, limit, offset int) (collection contract.Collection[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) {
+		err = errors.Wrap(e, "param: %v, %v, %v, %v, %v", matchFilter, addFields, sort, limit, offset)
+	})
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: c.buildFilter(matchFilter)}}}
+	if len(addFields) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: addFields}})
+	}
+	if len(sort) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+	}
+	if offset > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: int64(offset)}})
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: int64(limit)}})
+	}
+	pipeline = append(pipeline, c.projectionStages()...)
+	cursor, err := c.collection.Aggregate(ctx, pipeline, c.newAggregateOptions())
+	errors.Check(mapError(err))
+
+	var entities []ENTITY
+	err = cursor.All(ctx, &entities)
+	errors.Check(mapError(err))
+
+	collection = repository.NewCollection[ID](entities)
+	return
+}
+
+// AggregateEach runs pipeline, preceded by a `$match` of the soft delete scope as in AggregateOne, and calls fn
+// for each result as it is read from the cursor rather than loading them all. It stops at the first error of fn
+// and returns it. Like Stream, it uses no read timeout, as large pipelines may run for long.
+func (c *CrudRepository[ID, ENTITY]) AggregateEach(ctx context.Context, pipeline mongo.Pipeline, fn func(bson.M) error) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", pipeline) })
+	if scope := c.buildFilter(nil); len(scope) > 0 {
+		pipeline = append(mongo.Pipeline{{{Key: "$match", Value: scope}}}, pipeline...)
+	}
+	cursor, err := c.collection.Aggregate(ctx, pipeline, c.newAggregateOptions())
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var result bson.M
+		errors.Check(mapError(cursor.Decode(&result)))
+		errors.Check(fn(result))
+	}
+	errors.Check(mapError(cursor.Err()))
+	return
+}
+
+// CountByFilters counts the documents matching each of the named filters in a single `$facet` aggregation,
+// e.g. for the counters of a dashboard. Names must be valid field names, not starting with "$" nor holding ".".
+func (c *CrudRepository[ID, ENTITY]) CountByFilters(ctx context.Context, filters map[string]map[string]any) (counts map[string]int, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filters) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	counts = make(map[string]int, len(filters))
+	if len(filters) == 0 {
+		return
+	}
+	facets := bson.M{}
+	for name, filter := range filters {
+		if name == "" || strings.HasPrefix(name, "$") || strings.Contains(name, ".") {
+			errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid filter name: %q", name)))
+		}
+		facets[name] = bson.A{
+			bson.M{"$match": c.buildScopedFilter(filter, nil)},
+			bson.M{"$count": "count"},
+		}
+		counts[name] = 0
+	}
+	var pipeline mongo.Pipeline
+	if scope := c.buildFilter(nil); len(scope) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: scope}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: facets}})
+	cursor, err := c.collection.Aggregate(ctx, pipeline, c.newAggregateOptions())
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	var result map[string][]struct {
+		Count int `bson:"count"`
+	}
+	if cursor.Next(ctx) {
+		errors.Check(mapError(cursor.Decode(&result)))
+	}
+	errors.Check(mapError(cursor.Err()))
+	for name, facet := range result {
+		if len(facet) > 0 {
+			counts[name] = facet[0].Count
+		}
+	}
+	return
+}
+
+// timeBucketFormats maps the CountByTimeBucket units to `$dateToString` formats.
+var timeBucketFormats = map[string]string{
+	"day":  "%Y-%m-%d",
+	"hour": "%Y-%m-%dT%H",
+}
+
+// CountByTimeBucket counts the matched documents per UTC day or hour, as unit, of timeField,
+// keyed like "2024-01-02" for a day or "2024-01-02T15" for an hour.
+// timeField may hold dates or unix seconds. Documents missing the field are not counted.
+func (c *CrudRepository[ID, ENTITY]) CountByTimeBucket(ctx context.Context, timeField string, unit string, filter map[string]any) (counts contract.Dict[string, int], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", timeField, unit, filter) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	format, ok := timeBucketFormats[unit]
+	if !ok {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid time bucket unit: %s", unit)))
+	}
+	field := "$" + timeField
+	date := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": field}, "date"}},
+		field,
+		bson.M{"$toDate": bson.M{"$multiply": bson.A{field, 1000}}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{
+			c.buildFilter(filter),
+			bson.M{timeField: bson.M{"$exists": true, "$ne": nil}},
+		}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": format, "date": date}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := c.collection.Aggregate(ctx, pipeline, c.newAggregateOptions())
+	errors.Check(mapError(err))
+
+	var results []struct {
+		Bucket string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	err = cursor.All(ctx, &results)
+	errors.Check(mapError(err))
+	counts = repository.NewDictWithSize[string, int](len(results))
+	for _, result := range results {
+		counts.Set(result.Bucket, result.Count)
+	}
+	return
+}
+
+// AggregateOne runs pipeline on the documents of repo and decodes the first result into a T, reporting
+// whether there was one, e.g. for a `$group` total. The pipeline is preceded by a `$match` of the soft delete
+// scope of repo if any, so it cannot start with a stage that must come first, such as `$geoNear`.
+func AggregateOne[T any, ID comparable, ENTITY contract.ENTITY[ID]](ctx context.Context, repo *CrudRepository[ID, ENTITY], pipeline mongo.Pipeline) (result T, found bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", pipeline) })
+	ctx, cancel := repo.readContext(ctx)
+	defer cancel()
+	if scope := repo.buildFilter(nil); len(scope) > 0 {
+		pipeline = append(mongo.Pipeline{{{Key: "$match", Value: scope}}}, pipeline...)
+	}
+	cursor, err := repo.collection.Aggregate(ctx, pipeline, repo.newAggregateOptions().SetBatchSize(1))
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	if cursor.Next(ctx) {
+		errors.Check(mapError(cursor.Decode(&result)))
+		found = true
+	}
+	errors.Check(mapError(cursor.Err()))
+	return
+}
+
+// FindVersion finds the entity with the id, keeping in its embedded `versions` array only the element whose
+// `version` field is version, for entities that keep their history in such an array.
+// It returns ErrNotFound if the entity or the version does not exist.
+func (c *CrudRepository[ID, ENTITY]) FindVersion(ctx context.Context, id ID, version int64) (entity ENTITY, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", id, version) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: c.buildFilter(bson.M{c.idField: id, "versions.version": version})}},
+		{{Key: "$addFields", Value: bson.M{"versions": bson.M{"$filter": bson.M{
+			"input": "$versions",
+			"cond":  bson.M{"$eq": bson.A{"$$this.version", version}},
+		}}}}},
+		{{Key: "$limit", Value: 1}},
+	}
+	cursor, err := c.collection.Aggregate(ctx, pipeline, c.newAggregateOptions())
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	if !cursor.Next(ctx) {
+		errors.Check(mapError(cursor.Err()))
+		errors.Check(repository.ErrNotFound.WrapStack(mongo.ErrNoDocuments))
+	}
+	errors.Check(mapError(cursor.Decode(&entity)))
+	return
+}
@@ -0,0 +1,327 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestCrudRepository_CountDistinct(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CountDistinct err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	cnt, err := userRepository.CountDistinct(context.Background(), "name", nil)
+	errors.Check(errors.Wrap(err, "failed to count distinct name"))
+	assert.Equal(t, cnt, 0)
+
+	for _, name := range []string{"test", "test", "test2", "test3"} {
+		_, err = userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: name,
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	_, err = db.Collection("user").InsertOne(context.Background(), bson.M{"_id": idGen.Generate()})
+	errors.Check(errors.Wrap(err, "failed to insert document without name"))
+	err = userRepository.Delete(context.Background(), map[string]any{"name": "test3"})
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	cnt, err = userRepository.CountDistinct(context.Background(), "name", nil)
+	errors.Check(errors.Wrap(err, "failed to count distinct name"))
+	assert.Equal(t, cnt, 2)
+
+	cnt, err = userRepository.CountDistinct(context.Background(), "name", map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to count distinct name"))
+	assert.Equal(t, cnt, 1)
+}
+
+func TestCrudRepository_CountDistinct_Array(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CountDistinct_Array err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	_, err := db.Collection("user").InsertMany(context.Background(), []any{
+		bson.M{"_id": idGen.Generate(), "tags": bson.A{"a", "b"}},
+		bson.M{"_id": idGen.Generate(), "tags": bson.A{"b", "c"}},
+		bson.M{"_id": idGen.Generate(), "tags": "d"},
+		bson.M{"_id": idGen.Generate(), "tags": nil},
+		bson.M{"_id": idGen.Generate(), "tags": bson.A{}},
+		bson.M{"_id": idGen.Generate()},
+	})
+	errors.Check(errors.Wrap(err, "failed to insert documents"))
+
+	values, err := userRepository.Distinct(context.Background(), "tags", nil)
+	errors.Check(errors.Wrap(err, "failed to find distinct tags"))
+	cnt, err := userRepository.CountDistinct(context.Background(), "tags", nil)
+	errors.Check(errors.Wrap(err, "failed to count distinct tags"))
+	assert.Equal(t, cnt, len(values))
+	assert.Equal(t, cnt, 5)
+}
+
+type UserTags struct {
+	ID        int64    `json:"id" bson:"_id"`
+	Tags      []string `json:"tags" bson:"tags"`
+	DeletedAt int64    `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u *UserTags) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserTags) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_AggregateFind(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_AggregateFind err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserTags](db.Collection("user"))
+
+	users := []*UserTags{
+		{ID: idGen.Generate(), Tags: []string{"a"}},
+		{ID: idGen.Generate(), Tags: []string{"a", "b", "c"}},
+		{ID: idGen.Generate(), Tags: []string{"a", "b"}},
+		{ID: idGen.Generate(), Tags: []string{"a", "b", "c", "d"}},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	err := userRepository.DeleteByID(context.Background(), users[3].ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	addFields := bson.M{"tag_count": bson.M{"$size": "$tags"}}
+	sort := bson.D{{Key: "tag_count", Value: -1}}
+	collection, err := userRepository.AggregateFind(context.Background(), nil, addFields, sort, 0, 0)
+	errors.Check(errors.Wrap(err, "failed to aggregate user"))
+	assert.Equal(t, collection.IDs(), []int64{users[1].ID, users[2].ID, users[0].ID})
+
+	collection, err = userRepository.AggregateFind(context.Background(), nil, addFields, sort, 1, 1)
+	errors.Check(errors.Wrap(err, "failed to aggregate user"))
+	assert.Equal(t, collection.IDs(), []int64{users[2].ID})
+
+	// the sort sees the whole array, the entities only the slice
+	collection, err = userRepository.With(WithArraySlice("tags", 1)).AggregateFind(context.Background(), nil, addFields, sort, 0, 0)
+	errors.Check(errors.Wrap(err, "failed to aggregate user"))
+	assert.Equal(t, collection.IDs(), []int64{users[1].ID, users[2].ID, users[0].ID})
+	for _, user := range collection.All() {
+		assert.Equal(t, user.Tags, []string{"a"})
+	}
+}
+
+func TestCrudRepository_CountByTimeBucket(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CountByTimeBucket err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	day1 := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 1, 3, 23, 0, 0, 0, time.UTC)
+	documents := []any{
+		bson.M{"_id": idGen.Generate(), "created_at": day1.Unix(), "deleted_at": 0},
+		bson.M{"_id": idGen.Generate(), "created_at": day1, "deleted_at": 0},
+		bson.M{"_id": idGen.Generate(), "created_at": day2.Unix(), "deleted_at": 0},
+		bson.M{"_id": idGen.Generate(), "created_at": day2.Unix(), "deleted_at": 1},
+		bson.M{"_id": idGen.Generate(), "deleted_at": 0},
+	}
+	_, err := db.Collection("user").InsertMany(context.Background(), documents)
+	errors.Check(errors.Wrap(err, "failed to create users"))
+
+	counts, err := userRepository.CountByTimeBucket(context.Background(), "created_at", "day", nil)
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, counts.Len(), 2)
+	cnt, _ := counts.Get("2024-01-02")
+	assert.Equal(t, cnt, 2)
+	cnt, _ = counts.Get("2024-01-03")
+	assert.Equal(t, cnt, 1)
+
+	counts, err = userRepository.CountByTimeBucket(context.Background(), "created_at", "hour", nil)
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	cnt, _ = counts.Get("2024-01-02T10")
+	assert.Equal(t, cnt, 2)
+
+	_, err = userRepository.CountByTimeBucket(context.Background(), "created_at", "week", nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestAggregateOne(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestAggregateOne err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	projectRepository := NewCrudRepository[int64, *Project](db.Collection("project"))
+	type Total struct {
+		Spent int64 `bson:"spent"`
+	}
+	pipeline := mongo.Pipeline{{{Key: "$group", Value: bson.M{"_id": nil, "spent": bson.M{"$sum": "$spent"}}}}}
+
+	_, found, err := AggregateOne[Total](context.Background(), projectRepository, pipeline)
+	errors.Check(errors.Wrap(err, "failed to aggregate project"))
+	assert.Equal(t, found, false)
+
+	projects := []*Project{
+		{ID: idGen.Generate(), Spent: 10},
+		{ID: idGen.Generate(), Spent: 20},
+		{ID: idGen.Generate(), Spent: 40},
+	}
+	for _, project := range projects {
+		_, err = projectRepository.Create(context.Background(), project)
+		errors.Check(errors.Wrap(err, "failed to create project"))
+	}
+	err = projectRepository.DeleteByID(context.Background(), projects[2].ID)
+	errors.Check(errors.Wrap(err, "failed to delete project"))
+
+	total, found, err := AggregateOne[Total](context.Background(), projectRepository, pipeline)
+	errors.Check(errors.Wrap(err, "failed to aggregate project"))
+	assert.Equal(t, found, true)
+	assert.Equal(t, total.Spent, int64(30))
+}
+
+type UserVersion struct {
+	Version int64  `json:"version" bson:"version"`
+	Name    string `json:"name" bson:"name"`
+}
+
+type UserVersioned struct {
+	ID        int64         `json:"id" bson:"_id"`
+	Name      string        `json:"name" bson:"name"`
+	Versions  []UserVersion `json:"versions" bson:"versions"`
+	DeletedAt int64         `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u *UserVersioned) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserVersioned) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_FindVersion(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindVersion err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserVersioned](db.Collection("user"))
+	user := UserVersioned{
+		ID:   idGen.Generate(),
+		Name: "test3",
+		Versions: []UserVersion{
+			{Version: 1, Name: "test1"},
+			{Version: 2, Name: "test2"},
+			{Version: 3, Name: "test3"},
+		},
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	user2, err := userRepository.FindVersion(context.Background(), user.ID, 2)
+	errors.Check(errors.Wrap(err, "failed to find user version"))
+	assert.Equal(t, user2.Name, "test3")
+	assert.Equal(t, user2.Versions, []UserVersion{{Version: 2, Name: "test2"}})
+
+	_, err = userRepository.FindVersion(context.Background(), user.ID, 4)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+	_, err = userRepository.FindVersion(context.Background(), idGen.Generate(), 1)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+}
+
+func TestCrudRepository_CountByFilters(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CountByFilters err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](db.Collection("user"))
+	ids := make([]int64, 0, 5)
+	for _, status := range []string{"active", "active", "active", "pending", "banned"} {
+		id, err := userRepository.Create(context.Background(), &UserStatus{ID: idGen.Generate(), Name: "test", Status: status})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[0])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	counts, err := userRepository.CountByFilters(context.Background(), map[string]map[string]any{
+		"active":   {"status": "active"},
+		"inactive": {"status": bson.M{"$in": bson.A{"pending", "banned"}}},
+		"total":    {},
+		"archived": {"status": "archived"},
+	})
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, counts, map[string]int{"active": 2, "inactive": 2, "total": 4, "archived": 0})
+
+	counts, err = userRepository.CountByFilters(context.Background(), nil)
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, counts, map[string]int{})
+	_, err = userRepository.CountByFilters(context.Background(), map[string]map[string]any{"$bad": {}})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_Distinct(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Distinct err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](db.Collection("user"))
+	ids := make([]int64, 0, 4)
+	for _, status := range []string{"banned", "active", "active", "pending"} {
+		id, err := userRepository.Create(context.Background(), &UserStatus{ID: idGen.Generate(), Name: "test", Status: status})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[0])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	values, err := userRepository.Distinct(context.Background(), "status", nil)
+	errors.Check(errors.Wrap(err, "failed to find distinct statuses"))
+	assert.Equal(t, values, []any{"active", "pending"})
+
+	values, err = userRepository.Distinct(context.Background(), "status", map[string]any{"status": bson.M{"$ne": "pending"}})
+	errors.Check(errors.Wrap(err, "failed to find distinct statuses"))
+	assert.Equal(t, values, []any{"active"})
+
+	values, err = userRepository.DistinctUnscoped(context.Background(), "status", nil)
+	errors.Check(errors.Wrap(err, "failed to find distinct statuses"))
+	assert.Equal(t, values, []any{"active", "banned", "pending"})
+}
+
+func TestCrudRepository_AggregateEach(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_AggregateEach err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	ids := make([]int64, 0, 6)
+	for _, name := range []string{"a", "a", "b", "b", "b", "c"} {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{ID: idGen.Generate(), Name: name})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[5])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$name", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	counts := map[string]int32{}
+	err = userRepository.AggregateEach(context.Background(), pipeline, func(result bson.M) error {
+		counts[result["_id"].(string)] = result["count"].(int32)
+		return nil
+	})
+	errors.Check(errors.Wrap(err, "failed to aggregate users"))
+	assert.Equal(t, counts, map[string]int32{"a": 2, "b": 3})
+
+	stop := errors.New("stop")
+	var names []string
+	err = userRepository.AggregateEach(context.Background(), pipeline, func(result bson.M) error {
+		names = append(names, result["_id"].(string))
+		return stop
+	})
+	assert.Equal(t, errors.Is(err, stop), true)
+	assert.Equal(t, names, []string{"a"})
+}
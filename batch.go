@@ -0,0 +1,65 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-utils/uslice"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+)
+
+// forEachIDBatch splits the unique ids into batches and calls fn for each of them,
+// running up to batchConcurrency calls at the same time. It returns the first error, or panic of fn as an error.
+// Batches run sequentially inside a session, since a session must not be used concurrently.
+func (c *CrudRepository[ID, ENTITY]) forEachIDBatch(ctx context.Context, ids []ID, fn func(ctx context.Context, batch []ID) error) error {
+	call := func(ctx context.Context, batch []ID) (err error) {
+		defer errors.Recover(func(e error) { err = e })
+		return fn(ctx, batch)
+	}
+	size := c.config.batchSize
+	if size <= 0 {
+		size = len(ids)
+	}
+	batches := uslice.Chunk(uslice.Unique(ids), uint(size))
+	if c.config.batchConcurrency <= 1 || len(batches) == 1 || mongo.SessionFromContext(ctx) != nil {
+		for _, batch := range batches {
+			if err := call(ctx, batch); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, c.config.batchConcurrency)
+	)
+	for _, batch := range batches {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(batch []ID) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := call(ctx, batch); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(batch)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
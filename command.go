@@ -0,0 +1,15 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RunCommand runs command, e.g. collStats, on the database of the collection and returns the reply.
+func (c *CrudRepository[ID, ENTITY]) RunCommand(ctx context.Context, command bson.D) (reply bson.Raw, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", command) })
+	reply, err = c.collection.Database().RunCommand(ctx, command).Raw()
+	errors.Check(mapError(err))
+	return
+}
@@ -0,0 +1,31 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"log"
+	"testing"
+)
+
+func TestCrudRepository_RunCommand(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_RunCommand err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	_, err := userRepository.Create(context.Background(), &User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	reply, err := userRepository.RunCommand(context.Background(), bson.D{{Key: "collStats", Value: "user"}})
+	errors.Check(errors.Wrap(err, "failed to run command"))
+	_, err = reply.LookupErr("size")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, reply.Lookup("ns").StringValue(), "test.user")
+
+	_, err = userRepository.RunCommand(context.Background(), bson.D{{Key: "noSuchCommand", Value: 1}})
+	assert.Equal(t, err != nil, true)
+}
@@ -11,10 +11,15 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 var (
@@ -127,6 +132,75 @@ func TestCrudRepository_SoftDeleteEnabled(t *testing.T) {
 	assert.Equal(t, repository3.SoftDeleteEnabled(), false)
 }
 
+type BaseModel struct {
+	ID        int64 `json:"id" bson:"_id"`
+	DeletedAt int64 `json:"deleted_at" bson:"deleted_at"`
+}
+
+type UserInline struct {
+	BaseModel `bson:",inline"`
+	Name      string `json:"name" bson:"name"`
+}
+
+func (u *UserInline) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserInline) SetID(id int64) {
+	u.ID = id
+}
+
+type UserEmbedded struct {
+	BaseModel
+	Name string `json:"name" bson:"name"`
+}
+
+func (u *UserEmbedded) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserEmbedded) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_EmbeddedFields(t *testing.T) {
+	var collection *mongo.Collection
+	repository1 := NewCrudRepository[int64, *UserInline](collection)
+	assert.Equal(t, repository1.IDField(), "_id")
+	assert.Equal(t, repository1.SoftDeleteField(), "deleted_at")
+	repository2 := NewCrudRepository[int64, *UserEmbedded](collection)
+	assert.Equal(t, repository2.IDField(), "basemodel._id")
+	assert.Equal(t, repository2.SoftDeleteField(), "basemodel.deleted_at")
+}
+
+func TestCrudRepository_Inline(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Inline err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserInline](db.Collection("user"))
+
+	user := UserInline{
+		BaseModel: BaseModel{ID: idGen.Generate()},
+		Name:      "test",
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	err = userRepository.UpdateNonZeroByID(context.Background(), user.ID, &UserInline{Name: "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	user1, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user1.Name, "test2")
+
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to soft delete user"))
+	_, err = userRepository.FindByID(context.Background(), user.ID)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+	user2, err := userRepository.Unscoped().FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.DeletedAt > 0, true)
+}
+
 type User struct {
 	ID   int64  `json:"id" bson:"_id"`
 	Name string `json:"name" bson:"name"`
@@ -151,6 +225,40 @@ func getDatabase() (database *mongo.Database, teardown func()) {
 	}
 }
 
+func TestNewCrudRepositoryFromClient(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestNewCrudRepositoryFromClient err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepositoryFromClient[int64, *User](db.Client(), db.Name(), "user")
+
+	user := User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	cnt, err := db.Collection("user").CountDocuments(context.Background(), bson.M{"_id": user.ID})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, int64(1))
+}
+
+// getMonitoredDatabase is like getDatabase, and calls started for every command sent to the server.
+func getMonitoredDatabase(started func(evt *event.CommandStartedEvent)) (database *mongo.Database, teardown func()) {
+	defer errors.Recover(func(e error) { log.Fatalf("getMonitoredDatabase err: %+v", e) })
+	monitor := &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			started(evt)
+		},
+	}
+	mongoClient, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoEndpoint).SetMonitor(monitor))
+	errors.Check(errors.WithStack(err))
+	database = mongoClient.Database("test")
+	return database, func() {
+		err = database.Drop(context.Background())
+		errors.Check(errors.Wrap(err, "failed to drop database"))
+	}
+}
+
 func TestCrudRepository_Create(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Create err: %+v", e) })
 	db, teardown := getDatabase()
@@ -182,6 +290,159 @@ func TestCrudRepository_Create_DuplicateKey(t *testing.T) {
 	assert.Equal(t, errors.Is(err, repository.ErrDuplicatedKey), true)
 }
 
+type UserStringID struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name"`
+}
+
+func (u *UserStringID) GetID() string {
+	return u.ID
+}
+
+func (u *UserStringID) SetID(id string) {
+	u.ID = id
+}
+
+type UserObjectID struct {
+	ID   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name string             `json:"name" bson:"name"`
+}
+
+func (u *UserObjectID) GetID() primitive.ObjectID {
+	return u.ID
+}
+
+func (u *UserObjectID) SetID(id primitive.ObjectID) {
+	u.ID = id
+}
+
+func TestCrudRepository_Create_StringID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Create_StringID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[string, *UserStringID](db.Collection("user"))
+
+	user := UserStringID{
+		ID:   "7f1c2a4e-3b5d-4c6e-9f80-1a2b3c4d5e6f",
+		Name: "test",
+	}
+	id, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, id, user.ID)
+
+	user2, err := userRepository.FindByID(context.Background(), id)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, user.Name)
+}
+
+func TestCrudRepository_WithIDCoercion(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithIDCoercion err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	oids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	for _, oid := range oids {
+		_, err := db.Collection("user").InsertOne(context.Background(), bson.M{"_id": oid, "name": "test"})
+		errors.Check(errors.Wrap(err, "failed to insert user"))
+	}
+	ids := []string{oids[0].Hex(), oids[1].Hex()}
+
+	userRepository := NewCrudRepository[string, *UserStringID](db.Collection("user"))
+	_, err := userRepository.FindByID(context.Background(), ids[0])
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+
+	userRepository = NewCrudRepository[string, *UserStringID](db.Collection("user"), WithIDCoercion(ObjectIDFromHex))
+	user, err := userRepository.FindByID(context.Background(), ids[0])
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.ID, ids[0])
+	collection, err := userRepository.FindByIDs(context.Background(), ids)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.Count(), 2)
+
+	err = userRepository.DeleteByID(context.Background(), ids[1])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+}
+
+func TestCrudRepository_Create_GeneratedID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Create_GeneratedID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[primitive.ObjectID, *UserObjectID](db.Collection("user"))
+
+	user := UserObjectID{
+		Name: "test",
+	}
+	id, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, id.IsZero(), false)
+	assert.Equal(t, user.ID, id)
+}
+
+func TestCrudRepository_Create_RequireID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Create_RequireID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"), WithRequireID(true))
+
+	_, err := userRepository.Create(context.Background(), &User{Name: "test"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 0)
+
+	_, err = userRepository.Create(context.Background(), &User{ID: idGen.Generate(), Name: "test"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+}
+
+func TestCrudRepository_Create_Nil(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Create_Nil err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	_, err := userRepository.Create(context.Background(), nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	assert.Equal(t, err.Error(), "repository: invalid argument -> {entity is nil}")
+	_, err = userRepository.BatchCreate(context.Background(), []*User{{ID: idGen.Generate()}, nil})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 0)
+}
+
+func TestCrudRepository_BatchCreate(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_BatchCreate err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	existing := User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+	_, err := userRepository.Create(context.Background(), &existing)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	users := []*User{
+		{ID: idGen.Generate(), Name: "test1"},
+		{ID: existing.ID, Name: "test2"},
+		{ID: idGen.Generate(), Name: "test3"},
+	}
+	ids, err := userRepository.BatchCreate(context.Background(), users)
+	assert.Equal(t, errors.Is(err, repository.ErrDuplicatedKey), true)
+	assert.Equal(t, DuplicateKeyIndices(err), []int{1})
+	assert.Equal(t, ids, []int64{users[0].ID, users[2].ID})
+
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 3)
+	user, err := userRepository.FindByID(context.Background(), existing.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.Name, "test")
+}
+
 func TestCrudRepository_FindOne(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindOne err: %+v", e) })
 	db, teardown := getDatabase()
@@ -300,6 +561,65 @@ func TestCrudRepository_FindByIDs(t *testing.T) {
 	assert.Equal(t, dict.Value(user2.ID).Name, user2.Name)
 }
 
+func TestCrudRepository_FindByIDs_Batch(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByIDs_Batch err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"), WithBatchSize(1000), WithBatchConcurrency(4))
+
+	ids := make([]int64, 0, 5000)
+	documents := make([]any, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		user := &User{
+			ID:   idGen.Generate(),
+			Name: "test",
+		}
+		ids = append(ids, user.ID)
+		documents = append(documents, user)
+	}
+	_, err := db.Collection("user").InsertMany(context.Background(), documents)
+	errors.Check(errors.Wrap(err, "failed to create users"))
+
+	collection, err := userRepository.FindByIDs(context.Background(), append(ids, ids[:10]...))
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.Count(), 5000)
+	for _, id := range []int64{ids[0], ids[999], ids[1000], ids[4999]} {
+		assert.Equal(t, collection.Has(id), true)
+	}
+}
+
+func TestCrudRepository_FindDictByIDs(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindDictByIDs err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	ids := make([]int64, 0, 4)
+	for i := 0; i < 4; i++ {
+		id := idGen.Generate()
+		ids = append(ids, id)
+		if i%2 == 0 {
+			_, err := userRepository.Create(context.Background(), &User{
+				ID:   id,
+				Name: "test",
+			})
+			errors.Check(errors.Wrap(err, "failed to create user"))
+		}
+	}
+
+	found, missing, err := userRepository.FindDictByIDs(context.Background(), append(ids, ids[1]))
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, found.Len(), 2)
+	user, ok := found.Get(ids[2])
+	assert.Equal(t, ok, true)
+	assert.Equal(t, user.ID, ids[2])
+	assert.Equal(t, missing, []int64{ids[1], ids[3]})
+
+	found, missing, err = userRepository.FindDictByIDs(context.Background(), nil)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, found.Len(), 0)
+	assert.Equal(t, len(missing), 0)
+}
+
 func TestCrudRepository_FindByPage(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByPage err: %+v", e) })
 	db, teardown := getDatabase()
@@ -333,6 +653,37 @@ func TestCrudRepository_FindByPage(t *testing.T) {
 	assert.Equal(t, collection2.Count(), 1)
 }
 
+func TestCrudRepository_FindByPageHasMore(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByPageHasMore err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	ids := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		user := User{
+			ID:   idGen.Generate(),
+			Name: "test",
+		}
+		_, err := userRepository.Create(context.Background(), &user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, user.ID)
+	}
+	order := contract.Order{
+		Key:   userRepository.IDField(),
+		Value: 1,
+	}
+
+	collection, hasMore, err := userRepository.FindByPageHasMore(context.Background(), 2, 0, order)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, hasMore, true)
+	assert.Equal(t, collection.IDs(), ids[:2])
+
+	collection, hasMore, err = userRepository.FindByPageHasMore(context.Background(), 2, 2, order)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, hasMore, false)
+	assert.Equal(t, collection.IDs(), ids[2:])
+}
+
 func TestCrudRepository_FindByFilter(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilter err: %+v", e) })
 	db, teardown := getDatabase()
@@ -365,6 +716,92 @@ func TestCrudRepository_FindByFilter(t *testing.T) {
 	assert.Equal(t, collection2.Count(), 0)
 }
 
+func TestCrudRepository_FindByFilterWithOptions(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilterWithOptions err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	ids := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[0])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "name", Value: 0}}).
+		SetSort(bson.D{{Key: "_id", Value: -1}})
+	collection, err := userRepository.FindByFilterWithOptions(context.Background(), map[string]any{"name": "test"}, opts)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.IDs(), []int64{ids[2], ids[1]})
+	for _, user := range collection.All() {
+		assert.Equal(t, user.Name, "")
+	}
+}
+
+func TestCrudRepository_FindByFilterDict(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilterDict err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	users := []*User{
+		{ID: idGen.Generate(), Name: "test1"},
+		{ID: idGen.Generate(), Name: "test2"},
+		{ID: idGen.Generate(), Name: "other"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	dict, err := userRepository.FindByFilterDict(context.Background(), map[string]any{"name": bson.M{"$regex": "^test"}})
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, dict.Len(), 2)
+	user, ok := dict.Get(users[0].ID)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, user.Name, "test1")
+	user, ok = dict.Get(users[1].ID)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, user.Name, "test2")
+	_, ok = dict.Get(users[2].ID)
+	assert.Equal(t, ok, false)
+}
+
+func TestCrudRepository_FindByFilterIncludeDeleted(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilterIncludeDeleted err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	ids := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[1])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	order := contract.Order{Key: "_id", Value: 1}
+	collection, err := userRepository.FindByFilterIncludeDeleted(context.Background(), map[string]any{"name": "test"}, false, order)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.IDs(), []int64{ids[0], ids[2]})
+
+	collection, err = userRepository.FindByFilterIncludeDeleted(context.Background(), map[string]any{"name": "test"}, true, order)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.IDs(), ids)
+}
+
 func TestCrudRepository_FindByFilterWithPage(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilterWithPage err: %+v", e) })
 	db, teardown := getDatabase()
@@ -438,32 +875,124 @@ func TestCrudRepository_FindAll(t *testing.T) {
 	assert.Equal(t, collection1.Count(), 2)
 }
 
-func TestCrudRepository_Count(t *testing.T) {
-	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Count err: %+v", e) })
+func TestCrudRepository_FindAllOrderedByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindAllOrderedByID err: %+v", e) })
 	db, teardown := getDatabase()
 	defer teardown()
-	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
 
-	cnt, err := userRepository.Count(context.Background())
-	errors.Check(errors.Wrap(err, "failed to count user"))
-	assert.Equal(t, cnt, 0)
+	ids := make([]int64, 0, 4)
+	for i := 0; i < 4; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[1])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
 
-	user := User{
+	collection, err := userRepository.FindAllOrderedByID(context.Background(), true)
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.IDs(), []int64{ids[0], ids[2], ids[3]})
+
+	collection, err = userRepository.FindAllOrderedByID(context.Background(), false)
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.IDs(), []int64{ids[3], ids[2], ids[0]})
+}
+
+func TestCrudRepository_MinID_MaxID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_MinID_MaxID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	_, found, err := userRepository.MinID(context.Background())
+	errors.Check(errors.Wrap(err, "failed to get min id"))
+	assert.Equal(t, found, false)
+	_, found, err = userRepository.MaxID(context.Background())
+	errors.Check(errors.Wrap(err, "failed to get max id"))
+	assert.Equal(t, found, false)
+
+	ids := make([]int64, 0, 4)
+	for i := 0; i < 4; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err = userRepository.DeleteByIDs(context.Background(), []int64{ids[0], ids[3]})
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	minID, found, err := userRepository.MinID(context.Background())
+	errors.Check(errors.Wrap(err, "failed to get min id"))
+	assert.Equal(t, found, true)
+	assert.Equal(t, minID, ids[1])
+	maxID, found, err := userRepository.MaxID(context.Background())
+	errors.Check(errors.Wrap(err, "failed to get max id"))
+	assert.Equal(t, found, true)
+	assert.Equal(t, maxID, ids[2])
+}
+
+func TestCrudRepository_FindIDByFilter(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindIDByFilter err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	user := UserSoftDelete{
 		ID:   idGen.Generate(),
 		Name: "test",
 	}
-	_, err = userRepository.Create(context.Background(), &user)
+	_, err := userRepository.Create(context.Background(), &user)
 	errors.Check(errors.Wrap(err, "failed to create user"))
-	user2 := User{
+	user2 := UserSoftDelete{
 		ID:   idGen.Generate(),
 		Name: "test2",
 	}
 	_, err = userRepository.Create(context.Background(), &user2)
 	errors.Check(errors.Wrap(err, "failed to create user"))
-	cnt, err = userRepository.Count(context.Background())
-	errors.Check(errors.Wrap(err, "failed to count user"))
-	assert.Equal(t, cnt, 2)
-}
+	err = userRepository.DeleteByID(context.Background(), user2.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	id, found, err := userRepository.FindIDByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to find user id"))
+	assert.Equal(t, found, true)
+	assert.Equal(t, id, user.ID)
+
+	_, found, err = userRepository.FindIDByFilter(context.Background(), map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to find user id"))
+	assert.Equal(t, found, false)
+}
+
+func TestCrudRepository_Count(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Count err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 0)
+
+	user := User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+	_, err = userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	user2 := User{
+		ID:   idGen.Generate(),
+		Name: "test2",
+	}
+	_, err = userRepository.Create(context.Background(), &user2)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	cnt, err = userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+}
 
 func TestCrudRepository_CountByFilter(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CountByFilter err: %+v", e) })
@@ -521,6 +1050,73 @@ func TestCrudRepository_Exists(t *testing.T) {
 	assert.Equal(t, exists, true)
 }
 
+func TestCrudRepository_existsProjection(t *testing.T) {
+	var collection *mongo.Collection
+	userRepository := NewCrudRepository[int64, *User](collection)
+	assert.Equal(t, userRepository.existsProjection(nil), bson.D{{Key: "_id", Value: 1}})
+	assert.Equal(t, userRepository.existsProjection(map[string]any{"_id": 1}), bson.D{{Key: "_id", Value: 1}})
+	assert.Equal(t, userRepository.existsProjection(map[string]any{"name": "test", "$or": bson.A{}}), bson.D{
+		{Key: "_id", Value: 0},
+		{Key: "name", Value: 1},
+	})
+}
+
+func TestCrudRepository_Exists_Covered(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Exists_Covered err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	_, err := db.Collection("user").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: 1}},
+	})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	user := User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+	_, err = userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	for filter, expected := range map[string]bool{"test": true, "test2": false} {
+		exists, err := userRepository.Exists(context.Background(), map[string]any{"name": filter})
+		errors.Check(errors.Wrap(err, "failed to check user exists"))
+		assert.Equal(t, exists, expected)
+	}
+	exists, err := userRepository.Exists(context.Background(), map[string]any{"_id": user.ID, "name": "test"})
+	errors.Check(errors.Wrap(err, "failed to check user exists"))
+	assert.Equal(t, exists, true)
+}
+
+func BenchmarkCrudRepository_Exists(b *testing.B) {
+	defer errors.Recover(func(e error) { log.Fatalf("BenchmarkCrudRepository_Exists err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	collection := db.Collection("user")
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: 1}},
+	})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+	documents := make([]any, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		documents = append(documents, &User{ID: idGen.Generate(), Name: fmt.Sprintf("test%d", i)})
+	}
+	_, err = collection.InsertMany(context.Background(), documents)
+	errors.Check(errors.Wrap(err, "failed to create users"))
+
+	filter := bson.D{{Key: "name", Value: "test500"}}
+	for name, projection := range map[string]bson.D{
+		"id":      {{Key: "_id", Value: 1}},
+		"covered": {{Key: "_id", Value: 0}, {Key: "name", Value: 1}},
+	} {
+		b.Run(name, func(b *testing.B) {
+			opts := options.FindOne().SetProjection(projection)
+			for i := 0; i < b.N; i++ {
+				errors.Check(collection.FindOne(context.Background(), filter, opts).Err())
+			}
+		})
+	}
+}
+
 func TestCrudRepository_ExistsByID(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ExistsByID err: %+v", e) })
 	db, teardown := getDatabase()
@@ -564,6 +1160,60 @@ func TestCrudRepository_ExistsByIDs(t *testing.T) {
 	assert.Equal(t, ok2, false)
 }
 
+func TestCrudRepository_ExistsByIDs_Batch(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ExistsByIDs_Batch err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"), WithBatchSize(1000), WithBatchConcurrency(4))
+
+	ids := make([]int64, 0, 5000)
+	documents := make([]any, 0, 2500)
+	for i := 0; i < 5000; i++ {
+		id := idGen.Generate()
+		ids = append(ids, id)
+		if i%2 == 0 {
+			documents = append(documents, &User{
+				ID:   id,
+				Name: "test",
+			})
+		}
+	}
+	_, err := db.Collection("user").InsertMany(context.Background(), documents)
+	errors.Check(errors.Wrap(err, "failed to create users"))
+
+	exists, err := userRepository.ExistsByIDs(context.Background(), ids)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists.Len(), 2500)
+	for _, i := range []int{0, 1, 998, 999, 1000, 1001, 4998, 4999} {
+		_, ok := exists.Get(ids[i])
+		assert.Equal(t, ok, i%2 == 0)
+	}
+}
+
+func TestCrudRepository_FindPartialByIDs(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindPartialByIDs err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](db.Collection("user"))
+	user := UserStatus{
+		ID:     idGen.Generate(),
+		Name:   "test",
+		Status: "active",
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	missing := idGen.Generate()
+	dict, err := userRepository.FindPartialByIDs(context.Background(), []int64{user.ID, missing}, "name")
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, dict.Len(), 1)
+	partial, ok := dict.Get(user.ID)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, *partial, UserStatus{ID: user.ID, Name: "test"})
+	_, ok = dict.Get(missing)
+	assert.Equal(t, ok, false)
+}
+
 func TestCrudRepository_Update(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Update err: %+v", e) })
 	db, teardown := getDatabase()
@@ -616,6 +1266,145 @@ func TestCrudRepository_UpdateByID(t *testing.T) {
 	assert.Equal(t, user2.Name, "test2")
 }
 
+func TestCrudRepository_UpdateByIDReturningOld(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdateByIDReturningOld err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	ids := make([]int64, 0, 2)
+	for i := 0; i < 2; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[1])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	old, err := userRepository.UpdateByIDReturningOld(context.Background(), ids[0], map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	assert.Equal(t, old.Name, "test")
+	user, err := userRepository.FindByID(context.Background(), ids[0])
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.Name, "test2")
+
+	_, err = userRepository.UpdateByIDReturningOld(context.Background(), ids[1], map[string]any{"name": "test2"})
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+}
+
+func TestCrudRepository_UpdateByIDs(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdateByIDs err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	ids := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		id, err := userRepository.Create(context.Background(), &User{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+
+	matched, err := userRepository.UpdateByIDs(context.Background(), nil, map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	assert.Equal(t, matched, int64(0))
+
+	matched, err = userRepository.UpdateByIDs(context.Background(), ids[:2], map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	assert.Equal(t, matched, int64(2))
+
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+	user, err := userRepository.FindByID(context.Background(), ids[2])
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.Name, "test")
+}
+
+func TestCrudRepository_UpdateAll(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdateAll err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithEmptyFilterGuard(true))
+	ids := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[2])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	matched, err := userRepository.UpdateAll(context.Background(), map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	assert.Equal(t, matched, int64(2))
+
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+	user, err := userRepository.Unscoped().FindByID(context.Background(), ids[2])
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.Name, "test")
+}
+
+func TestCrudRepository_UpdateEach(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdateEach err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	ids := make([]int64, 0, 4)
+	for i := 0; i < 4; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{ID: idGen.Generate(), Name: "test"})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[3])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	matched, err := userRepository.UpdateEach(context.Background(), map[int64]map[string]any{
+		ids[0]:           {"name": "test0"},
+		ids[1]:           {"name": "test1"},
+		ids[2]:           {"name": "test2"},
+		ids[3]:           {"name": "test3"},
+		idGen.Generate(): {"name": "test4"},
+	})
+	errors.Check(errors.Wrap(err, "failed to update users"))
+	assert.Equal(t, matched, int64(3))
+
+	users, err := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user")).Unscoped().FindByIDs(context.Background(), ids)
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	dict := users.ToDict()
+	for i, name := range []string{"test0", "test1", "test2", "test"} {
+		user, _ := dict.Get(ids[i])
+		assert.Equal(t, user.Name, name)
+	}
+
+	matched, err = userRepository.UpdateEach(context.Background(), map[int64]map[string]any{ids[0]: {}})
+	errors.Check(errors.Wrap(err, "failed to update users"))
+	assert.Equal(t, matched, int64(0))
+}
+
+func TestCrudRepository_NonZeroFields(t *testing.T) {
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](db.Collection("user"))
+	assert.Equal(t, userRepository.NonZeroFields(&UserStatus{Name: "test", Status: "active"}), bson.M{
+		"name":   "test",
+		"status": "active",
+	})
+	assert.Equal(t, userRepository.NonZeroFields(&UserStatus{ID: 1}), bson.M{})
+	assert.Equal(t, userRepository.NonZeroFields(&UserStatus{}), bson.M{})
+	assert.Equal(t, userRepository.NonZeroFields(nil), bson.M{})
+}
+
 func TestCrudRepository_UpdateNonZero(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdateNonZero err: %+v", e) })
 	db, teardown := getDatabase()
@@ -651,6 +1440,49 @@ func TestCrudRepository_UpdateNonZero(t *testing.T) {
 	assert.Equal(t, user3.Name, "test2")
 }
 
+type UserProfile struct {
+	Bio string `json:"bio" bson:"bio"`
+}
+
+type UserWithProfile struct {
+	ID           int64  `json:"id" bson:"_id"`
+	Name         string `json:"name" bson:"name"`
+	*UserProfile `json:"profile" bson:"profile"`
+}
+
+func (u *UserWithProfile) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserWithProfile) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_UpdateNonZero_NilPointer(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdateNonZero_NilPointer err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserWithProfile](db.Collection("user"))
+
+	user := UserWithProfile{
+		ID:          idGen.Generate(),
+		Name:        "test",
+		UserProfile: &UserProfile{Bio: "bio"},
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	err = userRepository.UpdateNonZeroByID(context.Background(), user.ID, &UserWithProfile{Name: "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	err = userRepository.UpdateNonZero(context.Background(), map[string]any{"name": "test2"}, nil)
+	errors.Check(errors.Wrap(err, "failed to update user"))
+
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test2")
+	assert.Equal(t, *user2.UserProfile, UserProfile{Bio: "bio"})
+}
+
 func TestCrudRepository_UpdateNonZeroByID(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdateNonZeroByID err: %+v", e) })
 	db, teardown := getDatabase()
@@ -702,6 +1534,65 @@ func TestCrudRepository_Delete(t *testing.T) {
 	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
 }
 
+func TestCrudRepository_DeleteOne(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_DeleteOne err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	for i := 0; i < 3; i++ {
+		_, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	err := userRepository.DeleteOne(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+	cnt, err = userRepository.OnlyDeleted().Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count deleted user"))
+	assert.Equal(t, cnt, 1)
+
+	err = NewCrudRepository[int64, *User](db.Collection("user")).DeleteOne(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	cnt, err = userRepository.Unscoped().Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+}
+
+func TestCrudRepository_DeleteByFilterReturningCount(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_DeleteByFilterReturningCount err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	for _, name := range []string{"test", "test", "test", "test2"} {
+		_, err := userRepository.Create(context.Background(), &UserSoftDelete{ID: idGen.Generate(), Name: name})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	deleted, err := userRepository.DeleteByFilterReturningCount(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to delete users"))
+	assert.Equal(t, deleted, int64(3))
+	deleted, err = userRepository.DeleteByFilterReturningCount(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to delete users"))
+	assert.Equal(t, deleted, int64(0))
+
+	deleted, err = userRepository.With(WithOrderedSoftDelete(true)).DeleteByFilterReturningCount(context.Background(), map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to delete users"))
+	assert.Equal(t, deleted, int64(1))
+
+	unscoped := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithDefaultUnscoped(true))
+	deleted, err = unscoped.DeleteByFilterReturningCount(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to delete users"))
+	assert.Equal(t, deleted, int64(3))
+	cnt, err := unscoped.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, cnt, 1)
+}
+
 func TestCrudRepository_DeleteByID(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_DeleteByID err: %+v", e) })
 	db, teardown := getDatabase()
@@ -747,6 +1638,59 @@ func TestCrudRepository_DeleteByIDs(t *testing.T) {
 	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
 }
 
+func TestCrudRepository_RestoreByFilter(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_RestoreByFilter err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	users := []*UserSoftDelete{
+		{ID: idGen.Generate(), Name: "test1"},
+		{ID: idGen.Generate(), Name: "test1"},
+		{ID: idGen.Generate(), Name: "test2"},
+		{ID: idGen.Generate(), Name: "test1"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	err := userRepository.DeleteByIDs(context.Background(), []int64{users[0].ID, users[1].ID, users[2].ID})
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	restored, err := userRepository.RestoreByFilter(context.Background(), map[string]any{"name": "test1"})
+	errors.Check(errors.Wrap(err, "failed to restore user"))
+	assert.Equal(t, restored, int64(2))
+	collection, err := userRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.IDs(), []int64{users[0].ID, users[1].ID, users[3].ID})
+	collection, err = userRepository.OnlyDeleted().FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find deleted user"))
+	assert.Equal(t, collection.IDs(), []int64{users[2].ID})
+}
+
+func TestCrudRepository_PurgeDeletedBefore(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_PurgeDeletedBefore err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	now := time.Now()
+	users := []*UserSoftDelete{
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test", DeletedAt: now.Add(-2 * time.Hour).Unix()},
+		{ID: idGen.Generate(), Name: "test", DeletedAt: now.Unix()},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	deleted, err := userRepository.PurgeDeletedBefore(context.Background(), now.Add(-time.Hour))
+	errors.Check(errors.Wrap(err, "failed to purge user"))
+	assert.Equal(t, deleted, int64(1))
+	collection, err := userRepository.Unscoped().FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.IDs(), []int64{users[0].ID, users[2].ID})
+}
+
 func TestCrudRepository_DeleteAll(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_DeleteAll err: %+v", e) })
 	db, teardown := getDatabase()
@@ -792,6 +1736,40 @@ func (u *UserSoftDelete) SetID(id int64) {
 	u.ID = id
 }
 
+func TestCrudRepository_EmptyFilterGuard(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_EmptyFilterGuard err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithEmptyFilterGuard(true))
+	for i := 0; i < 2; i++ {
+		_, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	err := userRepository.Update(context.Background(), nil, map[string]any{"name": "test2"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.UpdateNonZero(context.Background(), map[string]any{}, &UserSoftDelete{Name: "test2"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.Delete(context.Background(), nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.DeleteOne(context.Background(), nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.DeleteAllByFilter(context.Background(), map[string]any{})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+
+	err = userRepository.DeleteAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to delete all user"))
+	cnt, err = userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 0)
+}
+
 func TestCrudRepository_SoftDelete(t *testing.T) {
 	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDelete err: %+v", e) })
 	db, teardown := getDatabase()
@@ -819,3 +1797,1219 @@ func TestCrudRepository_SoftDelete(t *testing.T) {
 	errors.Check(errors.Wrap(err, "failed to find user"))
 	assert.Equal(t, user2.DeletedAt > 0, true)
 }
+
+type userNameRepository struct {
+	*CrudRepository[int64, *User]
+}
+
+func (r *userNameRepository) FindByName(ctx context.Context, name string) (*User, error) {
+	return r.DecodeOne(r.Collection().FindOne(ctx, bson.M{"name": name}))
+}
+
+func TestCrudRepository_DecodeOne(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_DecodeOne err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	repo := &userNameRepository{NewCrudRepository[int64, *User](db.Collection("user"))}
+
+	user := User{ID: idGen.Generate(), Name: "test"}
+	_, err := repo.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	user2, err := repo.FindByName(context.Background(), "test")
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, *user2, user)
+
+	_, err = repo.FindByName(context.Background(), "test2")
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+}
+
+func TestCrudRepository_FindRawByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindRawByID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	user := UserSoftDelete{ID: idGen.Generate(), Name: "test"}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	raw, err := userRepository.FindRawByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, raw.Lookup("_id").Int64(), user.ID)
+	assert.Equal(t, raw.Lookup("name").StringValue(), "test")
+	var user2 UserSoftDelete
+	errors.Check(bson.Unmarshal(raw, &user2))
+	assert.Equal(t, user2, user)
+
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	_, err = userRepository.FindRawByID(context.Background(), user.ID)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+}
+
+func TestCrudRepository_FindByIDWithDeleted(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByIDWithDeleted err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	ids := make([]int64, 0, 2)
+	for i := 0; i < 2; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[1])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	user, deleted, err := userRepository.FindByIDWithDeleted(context.Background(), ids[0])
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.ID, ids[0])
+	assert.Equal(t, deleted, false)
+
+	user, deleted, err = userRepository.FindByIDWithDeleted(context.Background(), ids[1])
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.ID, ids[1])
+	assert.Equal(t, deleted, true)
+
+	_, _, err = userRepository.FindByIDWithDeleted(context.Background(), idGen.Generate())
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+}
+
+func TestCrudRepository_ValidateSoftDeleteConfig(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ValidateSoftDeleteConfig err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	err := userRepository.ValidateSoftDeleteConfig(context.Background())
+	errors.Check(errors.Wrap(err, "failed to validate empty collection"))
+
+	_, err = db.Collection("user").InsertOne(context.Background(), bson.M{"_id": idGen.Generate(), "name": "test", "removed_at": 0})
+	errors.Check(errors.Wrap(err, "failed to insert user"))
+	err = userRepository.ValidateSoftDeleteConfig(context.Background())
+	assert.Equal(t, errors.Is(err, ErrSoftDeleteMismatch), true)
+
+	_, err = userRepository.Create(context.Background(), &UserSoftDelete{
+		ID:   idGen.Generate(),
+		Name: "test",
+	})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	err = userRepository.ValidateSoftDeleteConfig(context.Background())
+	errors.Check(errors.Wrap(err, "failed to validate collection"))
+}
+
+func TestCrudRepository_OnlyDeleted(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_OnlyDeleted err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	users := []*UserSoftDelete{
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test2"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	err := userRepository.DeleteByIDs(context.Background(), []int64{users[0].ID, users[2].ID})
+	errors.Check(errors.Wrap(err, "failed to soft delete user"))
+
+	collection, err := userRepository.OnlyDeleted().FindByFilter(context.Background(), map[string]any{
+		"name": "test",
+	})
+	errors.Check(errors.Wrap(err, "failed to find deleted user"))
+	assert.Equal(t, collection.Count(), 1)
+	assert.Equal(t, collection.Has(users[0].ID), true)
+
+	cnt, err := userRepository.OnlyDeleted().Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count deleted user"))
+	assert.Equal(t, cnt, 2)
+
+	cnt, err = userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+
+	cnt, err = userRepository.OnlyDeleted().Unscoped().Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count all users"))
+	assert.Equal(t, cnt, 3)
+}
+
+func TestCrudRepository_OnlyDeleted_Delete(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_OnlyDeleted_Delete err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	users := []*UserSoftDelete{
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	err := userRepository.DeleteByIDs(context.Background(), []int64{users[0].ID, users[1].ID})
+	errors.Check(errors.Wrap(err, "failed to soft delete users"))
+	deleted, err := userRepository.Unscoped().FindByID(context.Background(), users[0].ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+
+	// deleting an active document through OnlyDeleted is a no-op, and a deleted one keeps its timestamp until purged
+	err = userRepository.OnlyDeleted().DeleteByID(context.Background(), users[2].ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	err = userRepository.OnlyDeleted().Delete(context.Background(), map[string]any{"_id": users[1].ID})
+	errors.Check(errors.Wrap(err, "failed to purge user"))
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, cnt, 2)
+	deleted2, err := userRepository.Unscoped().FindByID(context.Background(), users[0].ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, deleted2.DeletedAt, deleted.DeletedAt)
+
+	// empty the recycle bin
+	err = userRepository.OnlyDeleted().DeleteAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to purge users"))
+	cnt, err = userRepository.Unscoped().Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, cnt, 2)
+	collection, err := userRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.Has(users[2].ID), true)
+	assert.Equal(t, collection.Has(users[3].ID), true)
+}
+
+func TestCrudRepository_WithDefaultUnscoped(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithDefaultUnscoped err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithDefaultUnscoped(true))
+	assert.Equal(t, userRepository.IsUnscoped(), true)
+	users := []*UserSoftDelete{
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	scoped := userRepository.Scoped()
+	assert.Equal(t, scoped.IsUnscoped(), false)
+	err := scoped.DeleteByID(context.Background(), users[0].ID)
+	errors.Check(errors.Wrap(err, "failed to soft delete user"))
+
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+	user, err := userRepository.FindByID(context.Background(), users[0].ID)
+	errors.Check(errors.Wrap(err, "failed to find deleted user"))
+	assert.Equal(t, user.DeletedAt > 0, true)
+
+	cnt, err = scoped.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+	_, err = scoped.FindByID(context.Background(), users[0].ID)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+
+	cnt, err = userRepository.OnlyDeleted().(*CrudRepository[int64, *UserSoftDelete]).Scoped().Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+}
+
+func TestCrudRepository_SoftDelete_Ordered(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDelete_Ordered err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithOrderedSoftDelete(true))
+	for i := 0; i < 3; i++ {
+		_, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	before := time.Now().Unix()
+	err := userRepository.Delete(context.Background(), map[string]any{
+		"name": "test",
+	})
+	errors.Check(errors.Wrap(err, "failed to soft delete user"))
+
+	collection, err := userRepository.Unscoped().FindByPage(context.Background(), 10, 0, contract.Order{
+		Key:   userRepository.IDField(),
+		Value: 1,
+	})
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.Count(), 3)
+	users := collection.All()
+	// no stamp is before the deletion
+	assert.Equal(t, users[0].DeletedAt >= before, true)
+	for i := 1; i < len(users); i++ {
+		assert.Equal(t, users[i].DeletedAt > users[i-1].DeletedAt, true)
+	}
+
+	// more documents than a minute of distinct stamps are not deleted
+	for i := 0; i < 61; i++ {
+		_, err = userRepository.Create(context.Background(), &UserSoftDelete{ID: idGen.Generate(), Name: "test2"})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	err = userRepository.Delete(context.Background(), map[string]any{"name": "test2"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, cnt, 61)
+}
+
+func TestCrudRepository_UpsertNonZeroByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpsertNonZeroByID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	id := idGen.Generate()
+	err := userRepository.UpsertNonZeroByID(context.Background(), id, &UserSoftDelete{
+		Name: "test",
+	})
+	errors.Check(errors.Wrap(err, "failed to upsert user"))
+	user1, err := userRepository.FindByID(context.Background(), id)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user1.Name, "test")
+
+	var raw bson.M
+	err = db.Collection("user").FindOne(context.Background(), bson.M{"_id": id}).Decode(&raw)
+	errors.Check(errors.Wrap(err, "failed to find raw user"))
+	assert.Equal(t, raw["deleted_at"], int32(0))
+
+	err = userRepository.UpsertNonZeroByID(context.Background(), id, &UserSoftDelete{
+		Name: "test2",
+	})
+	errors.Check(errors.Wrap(err, "failed to upsert user"))
+	user2, err := userRepository.FindByID(context.Background(), id)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test2")
+
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+}
+
+func TestCrudRepository_FindOrCreate(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindOrCreate err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	user := UserSoftDelete{ID: idGen.Generate(), Name: "test1"}
+	user2, created, err := userRepository.FindOrCreate(context.Background(), map[string]any{"name": "test1"}, &user)
+	errors.Check(errors.Wrap(err, "failed to find or create user"))
+	assert.Equal(t, created, true)
+	assert.Equal(t, *user2, user)
+
+	user3, created, err := userRepository.FindOrCreate(context.Background(), map[string]any{"name": "test1"}, &UserSoftDelete{ID: idGen.Generate(), Name: "test1"})
+	errors.Check(errors.Wrap(err, "failed to find or create user"))
+	assert.Equal(t, created, false)
+	assert.Equal(t, *user3, user)
+
+	// a soft-deleted document does not match
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	user4 := UserSoftDelete{ID: idGen.Generate(), Name: "test1"}
+	user5, created, err := userRepository.FindOrCreate(context.Background(), map[string]any{"name": "test1"}, &user4)
+	errors.Check(errors.Wrap(err, "failed to find or create user"))
+	assert.Equal(t, created, true)
+	assert.Equal(t, user5.ID, user4.ID)
+
+	_, _, err = userRepository.FindOrCreate(context.Background(), map[string]any{"name": "test1"}, nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_CreateIfNotExists(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CreateIfNotExists err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	user := UserSoftDelete{ID: idGen.Generate(), Name: "test1"}
+	created, err := userRepository.CreateIfNotExists(context.Background(), map[string]any{"name": "test1"}, &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, created, true)
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, *user2, user)
+
+	created, err = userRepository.CreateIfNotExists(context.Background(), map[string]any{"name": "test1"}, &UserSoftDelete{ID: idGen.Generate(), Name: "test1"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, created, false)
+
+	// a soft-deleted document does not match
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	created, err = userRepository.CreateIfNotExists(context.Background(), map[string]any{"name": "test1"}, &UserSoftDelete{ID: idGen.Generate(), Name: "test1"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, created, true)
+
+	cnt, err := userRepository.Unscoped().CountByFilter(context.Background(), map[string]any{"name": "test1"})
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, cnt, 2)
+}
+
+func TestCrudRepository_CreateIfNotExists_ObjectID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CreateIfNotExists_ObjectID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[primitive.ObjectID, *UserObjectID](db.Collection("user"))
+
+	user := UserObjectID{Name: "test"}
+	created, err := userRepository.CreateIfNotExists(context.Background(), map[string]any{"name": "test"}, &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, created, true)
+	assert.Equal(t, user.ID.IsZero(), false)
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test")
+}
+
+func TestCrudRepository_FindOrCreate_Concurrent(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindOrCreate_Concurrent err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	_, err := db.Collection("user").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	createdCount := 0
+	ids := map[int64]bool{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, created, err := userRepository.FindOrCreate(context.Background(), map[string]any{"name": "test"}, &User{ID: idGen.Generate(), Name: "test"})
+			errors.Check(errors.Wrap(err, "failed to find or create user"))
+			mu.Lock()
+			defer mu.Unlock()
+			if created {
+				createdCount++
+			}
+			ids[user.ID] = true
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, createdCount, 1)
+	assert.Equal(t, len(ids), 1)
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, cnt, 1)
+}
+
+func TestCrudRepository_NextSequence(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_NextSequence err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	counterRepository := NewCrudRepository[string, *UserStringID](db.Collection("counter"))
+
+	value, err := counterRepository.NextSequence(context.Background(), "user", "seq")
+	errors.Check(errors.Wrap(err, "failed to allocate sequence"))
+	assert.Equal(t, value, int64(1))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	values := map[int64]bool{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := counterRepository.NextSequence(context.Background(), "user", "seq")
+			errors.Check(errors.Wrap(err, "failed to allocate sequence"))
+			mu.Lock()
+			defer mu.Unlock()
+			values[value] = true
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, len(values), 20)
+	for i := int64(2); i <= 21; i++ {
+		assert.Equal(t, values[i], true)
+	}
+
+	value, err = counterRepository.NextSequence(context.Background(), "order", "seq")
+	errors.Check(errors.Wrap(err, "failed to allocate sequence"))
+	assert.Equal(t, value, int64(1))
+
+	_, err = counterRepository.NextSequence(context.Background(), "user", "")
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_ClaimNext(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ClaimNext err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](db.Collection("user"))
+
+	ids := make([]int64, 0, 10)
+	for i := 0; i < 10; i++ {
+		id, err := userRepository.Create(context.Background(), &UserStatus{
+			ID:     idGen.Generate(),
+			Name:   "test",
+			Status: "pending",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[9])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	claimed := map[int64]string{}
+	for _, worker := range []string{"worker1", "worker2"} {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			for {
+				user, err := userRepository.ClaimNext(
+					context.Background(),
+					map[string]any{"status": "pending"},
+					map[string]any{"status": worker},
+					contract.Order{Key: "_id", Value: 1},
+				)
+				if errors.Is(err, repository.ErrNotFound) {
+					return
+				}
+				errors.Check(errors.Wrap(err, "failed to claim user"))
+				assert.Equal(t, user.Status, worker)
+				mu.Lock()
+				_, ok := claimed[user.ID]
+				assert.Equal(t, ok, false)
+				claimed[user.ID] = worker
+				mu.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(claimed), 9)
+	for _, id := range ids[:9] {
+		user, err := userRepository.FindByID(context.Background(), id)
+		errors.Check(errors.Wrap(err, "failed to find user"))
+		assert.Equal(t, user.Status, claimed[id])
+	}
+}
+
+func TestCrudRepository_UpsertByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpsertByID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	id := idGen.Generate()
+	err := userRepository.UpsertByID(context.Background(), id, map[string]any{"name": "test"}, map[string]any{"created_at": 1, "name": "ignored"})
+	errors.Check(errors.Wrap(err, "failed to upsert user"))
+	var raw bson.M
+	err = db.Collection("user").FindOne(context.Background(), bson.M{"_id": id}).Decode(&raw)
+	errors.Check(errors.Wrap(err, "failed to find raw user"))
+	assert.Equal(t, raw, bson.M{"_id": id, "name": "test", "created_at": int32(1), "deleted_at": int32(0)})
+
+	err = userRepository.UpsertByID(context.Background(), id, map[string]any{"name": "test2"}, map[string]any{"created_at": 2})
+	errors.Check(errors.Wrap(err, "failed to upsert user"))
+	err = db.Collection("user").FindOne(context.Background(), bson.M{"_id": id}).Decode(&raw)
+	errors.Check(errors.Wrap(err, "failed to find raw user"))
+	assert.Equal(t, raw, bson.M{"_id": id, "name": "test2", "created_at": int32(1), "deleted_at": int32(0)})
+
+	id2 := idGen.Generate()
+	err = userRepository.Upsert(context.Background(), map[string]any{"name": "test3"}, nil, map[string]any{"_id": id2})
+	errors.Check(errors.Wrap(err, "failed to upsert user"))
+	user, err := userRepository.FindOne(context.Background(), map[string]any{"name": "test3"})
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.ID, id2)
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+
+	err = NewCrudRepository[int64, *User](db.Collection("user")).UpsertByID(context.Background(), id, nil, nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_UpsertManyBy(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpsertManyBy err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](db.Collection("user"))
+	existing := UserStatus{
+		ID:     idGen.Generate(),
+		Name:   "test1",
+		Status: "old",
+	}
+	_, err := userRepository.Create(context.Background(), &existing)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	_, err = db.Collection("user").UpdateByID(context.Background(), existing.ID, bson.M{"$set": bson.M{"stale": true}})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+
+	users := []*UserStatus{
+		{ID: idGen.Generate(), Name: "test1", Status: "new"},
+		{ID: idGen.Generate(), Name: "test2", Status: "first"},
+		{ID: idGen.Generate(), Name: "test2", Status: "last"},
+	}
+	matched, upserted, err := userRepository.UpsertManyBy(context.Background(), "name", users)
+	errors.Check(errors.Wrap(err, "failed to upsert user"))
+	assert.Equal(t, matched, int64(1))
+	assert.Equal(t, upserted, int64(1))
+
+	user, err := userRepository.FindOne(context.Background(), map[string]any{"name": "test1"})
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, *user, UserStatus{ID: existing.ID, Name: "test1", Status: "new"})
+	// matched documents are replaced, not merged
+	raw, err := db.Collection("user").FindOne(context.Background(), bson.M{"_id": existing.ID}).Raw()
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	_, err = raw.LookupErr("stale")
+	assert.Equal(t, err != nil, true)
+	user, err = userRepository.FindOne(context.Background(), map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, *user, UserStatus{ID: users[2].ID, Name: "test2", Status: "last"})
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+
+	_, _, err = userRepository.UpsertManyBy(context.Background(), "email", users)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+
+	// entities are written with the soft delete active value, but not modified
+	softDeleteRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user_soft_delete"), WithSoftDeleteActiveValue(-1))
+	softDeleteUser := &UserSoftDelete{ID: idGen.Generate(), Name: "test3"}
+	_, upserted, err = softDeleteRepository.UpsertManyBy(context.Background(), "name", []*UserSoftDelete{softDeleteUser})
+	errors.Check(errors.Wrap(err, "failed to upsert user"))
+	assert.Equal(t, upserted, int64(1))
+	assert.Equal(t, softDeleteUser.DeletedAt, int64(0))
+	softDeleted, err := softDeleteRepository.FindByID(context.Background(), softDeleteUser.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, softDeleted.DeletedAt, int64(-1))
+}
+
+func TestCrudRepository_SoftDeleteActiveValue(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDeleteActiveValue err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithSoftDeleteActiveValue(-1))
+
+	user := UserSoftDelete{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, user.DeletedAt, int64(-1))
+	_, err = db.Collection("user").InsertOne(context.Background(), bson.M{
+		"_id":        idGen.Generate(),
+		"name":       "test",
+		"deleted_at": 0,
+	})
+	errors.Check(errors.Wrap(err, "failed to insert user"))
+
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to soft delete user"))
+	_, err = userRepository.FindByID(context.Background(), user.ID)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+
+	user2, err := userRepository.Unscoped().FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.DeletedAt > 0, true)
+
+	cnt, err = userRepository.OnlyDeleted().Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count deleted user"))
+	assert.Equal(t, cnt, 2)
+}
+
+type UserStatus struct {
+	ID        int64  `json:"id" bson:"_id"`
+	Name      string `json:"name" bson:"name"`
+	Status    string `json:"status" bson:"status"`
+	DeletedAt int64  `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u *UserStatus) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserStatus) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_SoftDeleteFlag(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDeleteFlag err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithSoftDeleteFlag("is_deleted"))
+	ids := make([]int64, 0, 2)
+	for i := 0; i < 2; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+
+	err := userRepository.DeleteByID(context.Background(), ids[0])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	var raw bson.M
+	err = db.Collection("user").FindOne(context.Background(), bson.M{"_id": ids[0]}).Decode(&raw)
+	errors.Check(errors.Wrap(err, "failed to find raw user"))
+	assert.Equal(t, raw["is_deleted"], true)
+	assert.Equal(t, raw["deleted_at"].(int64) > 0, true)
+
+	collection, err := userRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.IDs(), []int64{ids[1]})
+
+	// the flag alone decides, whatever the timestamp
+	_, err = db.Collection("user").UpdateByID(context.Background(), ids[0], bson.M{"$set": bson.M{"is_deleted": false}})
+	errors.Check(errors.Wrap(err, "failed to update raw user"))
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+	_, err = db.Collection("user").UpdateByID(context.Background(), ids[1], bson.M{"$set": bson.M{"is_deleted": true}})
+	errors.Check(errors.Wrap(err, "failed to update raw user"))
+	collection, err = userRepository.OnlyDeleted().FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find deleted user"))
+	assert.Equal(t, collection.IDs(), []int64{ids[1]})
+}
+
+func TestCrudRepository_SoftDeleteUpdater(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDeleteUpdater err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](
+		db.Collection("user"),
+		WithSoftDeleteUpdater(func() bson.M {
+			return bson.M{"deleted_at": time.Now().Unix(), "status": "deleted"}
+		}),
+		WithSoftDeleteActiveFilter(func() bson.M {
+			return bson.M{"status": bson.M{"$ne": "deleted"}}
+		}),
+	)
+	user := UserStatus{
+		ID:     idGen.Generate(),
+		Name:   "test",
+		Status: "active",
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	_, err = userRepository.Create(context.Background(), &UserStatus{
+		ID:     idGen.Generate(),
+		Name:   "test",
+		Status: "active",
+	})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to soft delete user"))
+
+	_, err = userRepository.FindByID(context.Background(), user.ID)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+	user1, err := userRepository.Unscoped().FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user1.Status, "deleted")
+	assert.Equal(t, user1.DeletedAt > 0, true)
+
+	cnt, err := userRepository.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+	collection, err := userRepository.OnlyDeleted().FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find deleted user"))
+	assert.Equal(t, collection.IDs(), []int64{user.ID})
+}
+
+func TestCrudRepository_SoftDeleteActiveFilter_Composite(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDeleteActiveFilter_Composite err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](
+		db.Collection("user"),
+		WithSoftDeleteActiveFilter(func() bson.M {
+			return bson.M{
+				"$or": bson.A{
+					bson.M{"deleted_at": 0},
+					bson.M{"deleted_at": bson.M{"$exists": false}},
+				},
+				"status": bson.M{"$ne": "archived"},
+			}
+		}),
+	)
+	users := []*UserStatus{
+		{ID: idGen.Generate(), Name: "a", Status: "active"},
+		{ID: idGen.Generate(), Name: "b", Status: "archived"},
+		{ID: idGen.Generate(), Name: "c", Status: "active"},
+		{ID: idGen.Generate(), Name: "d", Status: "active"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	_, err := db.Collection("user").UpdateByID(context.Background(), users[2].ID, bson.M{"$set": bson.M{"deleted_at": time.Now().Unix()}})
+	errors.Check(errors.Wrap(err, "failed to update raw user"))
+
+	collection, err := userRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.IDs(), []int64{users[0].ID, users[3].ID})
+
+	// the caller's `$or` composes with the one of the active predicate
+	collection, err = userRepository.FindByFilter(context.Background(), map[string]any{
+		"$or": bson.A{
+			bson.M{"name": "a"},
+			bson.M{"name": "b"},
+			bson.M{"name": "c"},
+		},
+	})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.IDs(), []int64{users[0].ID})
+
+	collection, err = userRepository.OnlyDeleted().FindByFilter(context.Background(), map[string]any{
+		"$or": bson.A{
+			bson.M{"name": "a"},
+			bson.M{"name": "b"},
+			bson.M{"name": "c"},
+		},
+	})
+	errors.Check(errors.Wrap(err, "failed to find deleted users"))
+	assert.Equal(t, collection.IDs(), []int64{users[1].ID, users[2].ID})
+
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{
+		"$and": bson.A{bson.M{"name": bson.M{"$ne": "a"}}},
+	})
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, cnt, 1)
+}
+
+type UserValue struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	DeletedAt int64              `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u UserValue) GetID() primitive.ObjectID {
+	return u.ID
+}
+
+func (u UserValue) SetID(primitive.ObjectID) {}
+
+func TestCrudRepository_CreateReturning(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CreateReturning err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[primitive.ObjectID, UserValue](db.Collection("user"), WithSoftDeleteActiveValue(-1))
+
+	user, err := userRepository.CreateReturning(context.Background(), UserValue{Name: "test"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, user.ID.IsZero(), false)
+	assert.Equal(t, user.Name, "test")
+	assert.Equal(t, user.DeletedAt, int64(-1))
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2, user)
+
+	_, err = userRepository.CreateReturning(context.Background(), UserValue{ID: user.ID, Name: "test2"})
+	assert.Equal(t, errors.Is(err, repository.ErrDuplicatedKey), true)
+}
+
+func TestCrudRepository_ValueEntity(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ValueEntity err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[primitive.ObjectID, UserValue](db.Collection("user"), WithSoftDeleteActiveValue(-1))
+	assert.Equal(t, userRepository.IDField(), "_id")
+	assert.Equal(t, userRepository.SoftDeleteField(), "deleted_at")
+
+	user := UserValue{Name: "test"}
+	id, err := userRepository.Create(context.Background(), user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	assert.Equal(t, id.IsZero(), false)
+	assert.Equal(t, user.ID.IsZero(), true)
+	id2, err := userRepository.Create(context.Background(), UserValue{ID: primitive.NewObjectID(), Name: "test"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	user2, err := userRepository.FindByID(context.Background(), id)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2, UserValue{ID: id, Name: "test", DeletedAt: -1})
+
+	collection, err := userRepository.FindByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.IDs(), []primitive.ObjectID{id, id2})
+	collection, err = userRepository.FindByIDs(context.Background(), []primitive.ObjectID{id2})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.All()[0].ID, id2)
+
+	err = userRepository.UpdateNonZeroByID(context.Background(), id, UserValue{Name: "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	err = userRepository.DeleteByID(context.Background(), id2)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	collection, err = userRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.All(), []UserValue{{ID: id, Name: "test2", DeletedAt: -1}})
+}
+
+type UserCode struct {
+	ID   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Code string             `json:"code" bson:"code"`
+	Name string             `json:"name" bson:"name"`
+}
+
+func (u *UserCode) GetID() string {
+	return u.Code
+}
+
+func (u *UserCode) SetID(code string) {
+	u.Code = code
+}
+
+func TestCrudRepository_WithIDField(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithIDField err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[string, *UserCode](db.Collection("user"), WithIDField("code"))
+	assert.Equal(t, userRepository.IDField(), "code")
+	for _, code := range []string{"a", "b", "c"} {
+		id, err := userRepository.Create(context.Background(), &UserCode{Code: code, Name: "test"})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		assert.Equal(t, id, code)
+	}
+	_, err := userRepository.Create(context.Background(), &UserCode{Name: "test"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	_, err = userRepository.BatchCreate(context.Background(), []*UserCode{{Code: "d", Name: "test"}, {Name: "test"}})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	count, err := db.Collection("user").CountDocuments(context.Background(), bson.M{})
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, count, int64(3))
+
+	// the detected field holds the ObjectID, so the codes match nothing
+	collection, err := NewCrudRepository[string, *UserCode](db.Collection("user")).FindByIDs(context.Background(), []string{"a", "b"})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.Count(), 0)
+
+	collection, err = userRepository.FindByIDs(context.Background(), []string{"a", "b"})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.IDs(), []string{"a", "b"})
+	user, err := userRepository.FindByID(context.Background(), "c")
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.ID.IsZero(), false)
+
+	err = userRepository.DeleteByIDs(context.Background(), []string{"a", "c"})
+	errors.Check(errors.Wrap(err, "failed to delete users"))
+	exists, err := userRepository.ExistsByIDs(context.Background(), []string{"a", "b", "c"})
+	errors.Check(errors.Wrap(err, "failed to check users"))
+	for code, want := range map[string]bool{"a": false, "b": true, "c": false} {
+		got, _ := exists.Get(code)
+		assert.Equal(t, got, want)
+	}
+}
+
+type UserFeature struct {
+	ID        int64 `json:"id" bson:"_id"`
+	Beta      bool  `json:"beta" bson:"beta"`
+	DeletedAt int64 `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u *UserFeature) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserFeature) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_ToggleByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ToggleByID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserFeature](db.Collection("user"))
+	user := UserFeature{ID: idGen.Generate()}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	value, err := userRepository.ToggleByID(context.Background(), user.ID, "beta")
+	errors.Check(errors.Wrap(err, "failed to toggle user"))
+	assert.Equal(t, value, true)
+	value, err = userRepository.ToggleByID(context.Background(), user.ID, "beta")
+	errors.Check(errors.Wrap(err, "failed to toggle user"))
+	assert.Equal(t, value, false)
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, *user2, user)
+
+	// a missing field counts as false
+	value, err = userRepository.ToggleByID(context.Background(), user.ID, "alpha")
+	errors.Check(errors.Wrap(err, "failed to toggle user"))
+	assert.Equal(t, value, true)
+
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	_, err = userRepository.ToggleByID(context.Background(), user.ID, "beta")
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+	_, err = userRepository.ToggleByID(context.Background(), user.ID, "")
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+type UserFullName struct {
+	ID        int64  `json:"id" bson:"_id"`
+	FirstName string `json:"first_name" bson:"first_name"`
+	LastName  string `json:"last_name" bson:"last_name"`
+	FullName  string `json:"full_name" bson:"full_name"`
+	DeletedAt int64  `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u *UserFullName) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserFullName) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_UpdatePipelineByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_UpdatePipelineByID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserFullName](db.Collection("user"))
+	users := []*UserFullName{
+		{ID: idGen.Generate(), FirstName: "Ada", LastName: "Lovelace"},
+		{ID: idGen.Generate(), FirstName: "Alan", LastName: "Turing"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	err := userRepository.DeleteByID(context.Background(), users[1].ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	pipeline := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"full_name": bson.M{"$concat": bson.A{"$first_name", " ", "$last_name"}},
+	}}}}
+	for _, user := range users {
+		err = userRepository.UpdatePipelineByID(context.Background(), user.ID, pipeline)
+		errors.Check(errors.Wrap(err, "failed to update user"))
+	}
+
+	user, err := userRepository.FindByID(context.Background(), users[0].ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.FullName, "Ada Lovelace")
+	user, err = NewCrudRepository[int64, *UserFullName](db.Collection("user")).Unscoped().FindByID(context.Background(), users[1].ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.FullName, "")
+
+	err = userRepository.UpdatePipelineByID(context.Background(), users[0].ID, nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_PatchByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_PatchByID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserFullName](db.Collection("user"))
+	users := []*UserFullName{
+		{ID: idGen.Generate(), FirstName: "Ada", LastName: "Lovelace"},
+		{ID: idGen.Generate(), FirstName: "Alan", LastName: "Turing"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	_, err := db.Collection("user").UpdateByID(context.Background(), users[0].ID, bson.M{"$set": bson.M{
+		"address": bson.M{"city": "London", "street": "St James's Square"},
+	}})
+	errors.Check(errors.Wrap(err, "failed to set address"))
+	err = userRepository.DeleteByID(context.Background(), users[1].ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	patch := map[string]any{
+		"first_name": "Augusta Ada",
+		"last_name":  nil,
+		"address":    map[string]any{"street": nil, "country": "UK"},
+	}
+	for _, user := range users {
+		err = userRepository.PatchByID(context.Background(), user.ID, patch)
+		errors.Check(errors.Wrap(err, "failed to patch user"))
+	}
+
+	raw, err := db.Collection("user").FindOne(context.Background(), bson.M{"_id": users[0].ID}).Raw()
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, raw.Lookup("first_name").StringValue(), "Augusta Ada")
+	_, err = raw.LookupErr("last_name")
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, raw.Lookup("address", "city").StringValue(), "London")
+	assert.Equal(t, raw.Lookup("address", "country").StringValue(), "UK")
+	_, err = raw.LookupErr("address", "street")
+	assert.Equal(t, err != nil, true)
+
+	user, err := NewCrudRepository[int64, *UserFullName](db.Collection("user")).Unscoped().FindByID(context.Background(), users[1].ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user.FirstName, "Alan")
+	assert.Equal(t, user.LastName, "Turing")
+
+	err = userRepository.PatchByID(context.Background(), users[0].ID, map[string]any{
+		"address": bson.M{"city": nil, "zip": "SW1Y"},
+	})
+	errors.Check(errors.Wrap(err, "failed to patch user"))
+	raw, err = db.Collection("user").FindOne(context.Background(), bson.M{"_id": users[0].ID}).Raw()
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	_, err = raw.LookupErr("address", "city")
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, raw.Lookup("address", "country").StringValue(), "UK")
+	assert.Equal(t, raw.Lookup("address", "zip").StringValue(), "SW1Y")
+
+	err = userRepository.PatchByID(context.Background(), users[0].ID, map[string]any{})
+	errors.Check(errors.Wrap(err, "failed to patch user"))
+
+	_, err = db.Collection("user").UpdateByID(context.Background(), users[0].ID, bson.M{"$set": bson.M{"address": "London"}})
+	errors.Check(errors.Wrap(err, "failed to set address"))
+	err = userRepository.PatchByID(context.Background(), users[0].ID, map[string]any{
+		"address": map[string]any{"city": "London", "street": nil},
+		"tags":    map[string]any{},
+	})
+	errors.Check(errors.Wrap(err, "failed to patch user"))
+	raw, err = db.Collection("user").FindOne(context.Background(), bson.M{"_id": users[0].ID}).Raw()
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, raw.Lookup("address", "city").StringValue(), "London")
+	_, err = raw.LookupErr("address", "street")
+	assert.Equal(t, err != nil, true)
+	tags, err := raw.LookupErr("tags")
+	errors.Check(errors.Wrap(err, "failed to find tags"))
+	assert.Equal(t, tags.Type, bson.TypeEmbeddedDocument)
+	elements, err := tags.Document().Elements()
+	errors.Check(errors.Wrap(err, "failed to read tags"))
+	assert.Equal(t, len(elements), 0)
+}
+
+type UserDeletedTime struct {
+	ID        int64     `json:"id" bson:"_id"`
+	Name      string    `json:"name" bson:"name"`
+	DeletedAt time.Time `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u *UserDeletedTime) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserDeletedTime) SetID(id int64) {
+	u.ID = id
+}
+
+type UserDeletedFlag struct {
+	ID        int64  `json:"id" bson:"_id"`
+	Name      string `json:"name" bson:"name"`
+	DeletedAt bool   `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (u *UserDeletedFlag) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserDeletedFlag) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_DetectSoftDeleteType(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_DetectSoftDeleteType err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+
+	assert.Equal(t, NewCrudRepository[int64, *User](db.Collection("user")).DetectSoftDeleteType(), reflect.Invalid)
+	assert.Equal(t, NewCrudRepository[int64, *UserSoftDelete](db.Collection("user")).DetectSoftDeleteType(), reflect.Int64)
+	assert.Equal(t, NewCrudRepository[int64, *UserDeletedTime](db.Collection("user")).DetectSoftDeleteType(), reflect.Struct)
+	assert.Equal(t, NewCrudRepository[int64, *UserDeletedDate](db.Collection("user")).DetectSoftDeleteType(), reflect.Struct)
+	assert.Equal(t, NewCrudRepository[int64, *UserDeletedFlag](db.Collection("user")).DetectSoftDeleteType(), reflect.Bool)
+}
+
+func TestCrudRepository_SoftDelete_Time(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDelete_Time err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserDeletedTime](db.Collection("user"))
+	user := UserDeletedTime{ID: idGen.Generate(), Name: "test"}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	exists, err := userRepository.ExistsByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, true)
+
+	before := time.Now().Add(-time.Second)
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	_, err = userRepository.FindByID(context.Background(), user.ID)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+	deleted, err := userRepository.Unscoped().FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, deleted.DeletedAt.After(before), true)
+
+	restored, err := userRepository.RestoreByFilter(context.Background(), map[string]any{"_id": user.ID})
+	errors.Check(errors.Wrap(err, "failed to restore user"))
+	assert.Equal(t, restored, int64(1))
+	exists, err = userRepository.ExistsByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, true)
+}
+
+func TestCrudRepository_SoftDelete_Bool(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SoftDelete_Bool err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserDeletedFlag](db.Collection("user"), WithOrderedSoftDelete(true))
+	users := []*UserDeletedFlag{
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test2"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	err := userRepository.Delete(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to delete users"))
+	collection, err := userRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.IDs(), []int64{users[2].ID})
+	deleted, err := userRepository.Unscoped().FindByID(context.Background(), users[0].ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, deleted.DeletedAt, true)
+}
+
+func TestCrudRepository_CompareAndSetByID(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_CompareAndSetByID err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserStatus](db.Collection("user"))
+	user := UserStatus{ID: idGen.Generate(), Name: "test", Status: "pending"}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	swapped, err := userRepository.CompareAndSetByID(context.Background(), user.ID, "status", "pending", "active")
+	errors.Check(errors.Wrap(err, "failed to set status"))
+	assert.Equal(t, swapped, true)
+	swapped, err = userRepository.CompareAndSetByID(context.Background(), user.ID, "status", "pending", "blocked")
+	errors.Check(errors.Wrap(err, "failed to set status"))
+	assert.Equal(t, swapped, false)
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Status, "active")
+
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	swapped, err = userRepository.CompareAndSetByID(context.Background(), user.ID, "status", "active", "blocked")
+	errors.Check(errors.Wrap(err, "failed to set status"))
+	assert.Equal(t, swapped, false)
+}
@@ -2,63 +2,390 @@ package repositorymongo
 
 import (
 	"context"
+	stderrors "errors"
 	"github.com/ace-zhaoy/errors"
 	"github.com/ace-zhaoy/go-repository"
 	"github.com/ace-zhaoy/go-repository/contract"
 	"github.com/ace-zhaoy/go-utils/umap"
 	"github.com/ace-zhaoy/go-utils/uslice"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// CrudRepository implements contract.CrudRepository on a mongo collection.
+// Every method runs in the session carried by ctx, if any, e.g. inside mongo.WithSession or a transaction,
+// so reads in a causally consistent session observe the session's earlier writes.
 type CrudRepository[ID comparable, ENTITY contract.ENTITY[ID]] struct {
 	collection        *mongo.Collection
 	unscoped          bool
+	onlyDeleted       bool
 	idField           string
 	softDeleteField   string
+	softDeleteType    reflect.Type
 	softDeleteEnabled bool
+	// registry encodes and decodes outside of collection methods: the one set on collection, if any, else the default
+	registry *bsoncodec.Registry
+	// readCollection is collection with the read concern of WithReadConcern, if any, for the single document reads
+	readCollection *mongo.Collection
+	config         config
 }
 
 var _ contract.CrudRepository[int64, contract.ENTITY[int64]] = (*CrudRepository[int64, contract.ENTITY[int64]])(nil)
 
-func NewCrudRepository[ID comparable, ENTITY contract.ENTITY[ID]](collection *mongo.Collection) *CrudRepository[ID, ENTITY] {
-	var entity ENTITY
+func NewCrudRepository[ID comparable, ENTITY contract.ENTITY[ID]](collection *mongo.Collection, opts ...Option) *CrudRepository[ID, ENTITY] {
+	cfg := config{
+		softDeleteActiveValue: 0,
+		batchSize:             1000,
+		batchConcurrency:      1,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var entity any = *new(ENTITY)
+	registry := cfg.registry
+	if cfg.discriminatorField != "" {
+		entity = cfg.sampleEntity()
+		if entity == nil {
+			panic(ErrInvalidArgument.WrapStack(errors.NewWithMessage("no types registered for the discriminator %q", cfg.discriminatorField)))
+		}
+		registry = cfg.discriminatorRegistry(reflect.TypeOf((*ENTITY)(nil)).Elem())
+	}
+	if registry != nil {
+		var err error
+		collection, err = collection.Clone(options.Collection().SetRegistry(registry))
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		registry = bson.DefaultRegistry
+	}
+	idField := cfg.idField
+	if idField == "" {
+		idField = getIDField(entity)
+	}
 	softDeleteField := getDeletedAtField(entity)
-	return &CrudRepository[ID, ENTITY]{
+	c := &CrudRepository[ID, ENTITY]{
 		collection:        collection,
-		idField:           getIDField(entity),
+		unscoped:          cfg.defaultUnscoped,
+		idField:           idField,
 		softDeleteField:   softDeleteField,
+		softDeleteType:    getDeletedAtType(entity),
 		softDeleteEnabled: softDeleteField != "",
+		registry:          registry,
+	}
+	if !cfg.softDeleteActiveValueSet {
+		switch {
+		case isDateType(c.softDeleteType):
+			cfg.softDeleteActiveValue = reflect.Zero(c.softDeleteType).Interface()
+			if c.softDeleteType.Kind() == reflect.Ptr {
+				cfg.softDeleteActiveValue = nil
+			}
+		case c.DetectSoftDeleteType() == reflect.Bool:
+			cfg.softDeleteActiveValue = false
+		}
 	}
+	c.config = cfg
+	c.readCollection = c.newReadCollection()
+	return c
+}
+
+// NewCrudRepositoryFromClient creates a repository on the collection collName of the database dbName.
+func NewCrudRepositoryFromClient[ID comparable, ENTITY contract.ENTITY[ID]](client *mongo.Client, dbName, collName string, opts ...Option) *CrudRepository[ID, ENTITY] {
+	return NewCrudRepository[ID, ENTITY](client.Database(dbName).Collection(collName), opts...)
 }
 
 func (c *CrudRepository[ID, ENTITY]) clone() *CrudRepository[ID, ENTITY] {
 	return &CrudRepository[ID, ENTITY]{
 		collection:        c.collection,
 		unscoped:          c.unscoped,
+		onlyDeleted:       c.onlyDeleted,
 		idField:           c.idField,
 		softDeleteField:   c.softDeleteField,
+		softDeleteType:    c.softDeleteType,
 		softDeleteEnabled: c.softDeleteEnabled,
+		registry:          c.registry,
+		readCollection:    c.readCollection,
+		config:            c.config,
 	}
 }
 
 func (c *CrudRepository[ID, ENTITY]) buildFilter(filter map[string]any) bson.D {
+	var scope bson.M
+	if c.softDeleteEnabled && c.onlyDeleted {
+		scope = c.deletedFilter()
+	} else if c.softDeleteEnabled && !c.unscoped {
+		scope = c.activeFilter()
+	}
+	return c.buildScopedFilter(filter, scope)
+}
+
+// buildScopedFilter is like buildFilter, with the soft delete predicate replaced by scope.
+// It panics on an invalid filter, so it must be called under errors.Recover.
+func (c *CrudRepository[ID, ENTITY]) buildScopedFilter(filter map[string]any, scope bson.M) bson.D {
+	errors.Check(validateFilter(filter))
 	d := bson.D{}
 	umap.Foreach(filter, func(k string, v any) {
+		if c.config.normalizeFilter {
+			v = normalizeFilterValue(v)
+		}
+		if c.config.coerceID != nil && k == c.idField {
+			v = coerceIDValue(v, c.config.coerceID)
+		}
 		d = append(d, bson.E{Key: k, Value: v})
 	})
-	if c.softDeleteEnabled && !c.unscoped {
-		d = append(d, bson.E{
-			Key: "$or", Value: bson.A{
-				bson.M{"deleted_at": 0},
-				bson.M{"deleted_at": bson.M{"$exists": false}},
-			},
+	if len(scope) == 0 {
+		return d
+	}
+	if len(d) == 0 {
+		umap.Foreach(scope, func(k string, v any) {
+			d = append(d, bson.E{Key: k, Value: v})
 		})
+		return d
+	}
+	// scope goes under `$and`, so that its operators such as `$or` compose with those of filter instead of
+	// clashing with them, while the fields of filter stay at the top level for upserts to copy
+	if _, ok := filter["$and"]; ok {
+		return bson.D{{Key: "$and", Value: bson.A{d, scope}}}
+	}
+	return append(d, bson.E{Key: "$and", Value: bson.A{scope}})
+}
+
+// readContext returns ctx with the WithReadTimeout deadline, unless ctx already has one.
+// The returned cancel function must be called once the read is done.
+func (c *CrudRepository[ID, ENTITY]) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withDefaultTimeout(ctx, c.config.readTimeout)
+}
+
+// writeContext is like readContext, with the WithWriteTimeout deadline.
+func (c *CrudRepository[ID, ENTITY]) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withDefaultTimeout(ctx, c.config.writeTimeout)
+}
+
+func withDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// newFindOptions returns find options carrying the WithComment comment, if any, as do the other new*Options.
+func (c *CrudRepository[ID, ENTITY]) newFindOptions() *options.FindOptions {
+	opts := options.Find()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
+	}
+	return opts
+}
+
+func (c *CrudRepository[ID, ENTITY]) newFindOneOptions() *options.FindOneOptions {
+	opts := options.FindOne()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
+	}
+	return opts
+}
+
+func (c *CrudRepository[ID, ENTITY]) newCountOptions() *options.CountOptions {
+	opts := options.Count()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
+	}
+	return opts
+}
+
+func (c *CrudRepository[ID, ENTITY]) newAggregateOptions() *options.AggregateOptions {
+	opts := options.Aggregate()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
+	}
+	return opts
+}
+
+func (c *CrudRepository[ID, ENTITY]) newUpdateOptions() *options.UpdateOptions {
+	opts := options.Update()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
+	}
+	return opts
+}
+
+func (c *CrudRepository[ID, ENTITY]) newFindOneAndUpdateOptions() *options.FindOneAndUpdateOptions {
+	opts := options.FindOneAndUpdate()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
 	}
+	return opts
+}
+
+func (c *CrudRepository[ID, ENTITY]) newBulkWriteOptions() *options.BulkWriteOptions {
+	opts := options.BulkWrite()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
+	}
+	return opts
+}
+
+func (c *CrudRepository[ID, ENTITY]) newDeleteOptions() *options.DeleteOptions {
+	opts := options.Delete()
+	if c.config.comment != "" {
+		opts.SetComment(c.config.comment)
+	}
+	return opts
+}
 
-	return d
+// findOptions returns the options shared by the find methods.
+// It panics on invalid options, so it must be called under errors.Recover.
+func (c *CrudRepository[ID, ENTITY]) findOptions() *options.FindOptions {
+	opts := c.newFindOptions()
+	if c.config.hint != nil {
+		errors.Check(validateHint(c.config.hint))
+		opts.SetHint(c.config.hint)
+	}
+	if projection := c.projection(); projection != nil {
+		opts.SetProjection(projection)
+	}
+	return opts
+}
+
+// unboundedFindOptions is like findOptions, for the finds without a limit, which WithMaxResults caps.
+func (c *CrudRepository[ID, ENTITY]) unboundedFindOptions() *options.FindOptions {
+	opts := c.findOptions()
+	if c.config.maxResults > 0 {
+		// one more than allowed, to tell a full result from an excess one
+		opts.SetLimit(int64(c.config.maxResults) + 1)
+	}
+	return opts
+}
+
+// checkMaxResults fails with ErrTooManyResults if n, the length of a result read with unboundedFindOptions,
+// exceeds the WithMaxResults limit.
+func (c *CrudRepository[ID, ENTITY]) checkMaxResults(n int) error {
+	if c.config.maxResults > 0 && n > c.config.maxResults {
+		return ErrTooManyResults.WrapStack(errors.NewWithMessage("more than %d results", c.config.maxResults))
+	}
+	return nil
+}
+
+// newReadCollection returns the collection for FindOne and the FindByID methods, with the read concern of
+// WithReadConcern if any.
+func (c *CrudRepository[ID, ENTITY]) newReadCollection() *mongo.Collection {
+	if c.config.readConcern == nil {
+		return c.collection
+	}
+	collection, err := c.collection.Clone(options.Collection().SetReadConcern(c.config.readConcern))
+	if err != nil {
+		panic(err)
+	}
+	return collection
+}
+
+// findOneOptions is like findOptions, for the single document reads.
+func (c *CrudRepository[ID, ENTITY]) findOneOptions() *options.FindOneOptions {
+	opts := c.newFindOneOptions()
+	if c.config.hint != nil {
+		errors.Check(validateHint(c.config.hint))
+		opts.SetHint(c.config.hint)
+	}
+	if projection := c.projection(); projection != nil {
+		opts.SetProjection(projection)
+	}
+	return opts
+}
+
+// decodableProjection returns the inclusion projection with the WithDiscriminator field added, if it is not
+// already included, since an entity cannot be decoded without it.
+func (c *CrudRepository[ID, ENTITY]) decodableProjection(projection bson.D) bson.D {
+	field := c.config.discriminatorField
+	if field == "" || uslice.Contains(uslice.Map(projection, func(e bson.E) string { return e.Key }), field) {
+		return projection
+	}
+	return append(projection, bson.E{Key: field, Value: 1})
+}
+
+// projection returns the projection of the WithDefaultProjection and WithArraySlice options, or nil if there are none.
+func (c *CrudRepository[ID, ENTITY]) projection() bson.D {
+	if len(c.config.defaultProjection) == 0 && len(c.config.arraySlices) == 0 {
+		return nil
+	}
+	projection := make(bson.D, 0, len(c.config.defaultProjection)+len(c.config.arraySlices))
+	projection = append(projection, c.config.defaultProjection...)
+	for _, slice := range c.config.arraySlices {
+		errors.Check(validateArraySlice(slice))
+		projection = append(projection, bson.E{Key: slice.Key, Value: bson.M{"$slice": slice.Value}})
+	}
+	return projection
+}
+
+// projectionStages returns the aggregation stages applying the projection of findOptions, or none. The array
+// slices are an `$addFields` stage rather than part of `$project`, which cannot mix expressions with exclusions.
+func (c *CrudRepository[ID, ENTITY]) projectionStages() mongo.Pipeline {
+	var stages mongo.Pipeline
+	if len(c.config.arraySlices) > 0 {
+		slices := make(bson.D, 0, len(c.config.arraySlices))
+		for _, slice := range c.config.arraySlices {
+			errors.Check(validateArraySlice(slice))
+			// like the find `$slice`, leave a field that is not an array as it is
+			path := "$" + slice.Key
+			slices = append(slices, bson.E{Key: slice.Key, Value: bson.M{"$cond": bson.A{
+				bson.M{"$isArray": path}, bson.M{"$slice": bson.A{path, slice.Value}}, path,
+			}}})
+		}
+		stages = append(stages, bson.D{{Key: "$addFields", Value: slices}})
+	}
+	if len(c.config.defaultProjection) > 0 {
+		stages = append(stages, bson.D{{Key: "$project", Value: c.config.defaultProjection}})
+	}
+	return stages
+}
+
+// countOptions is like findOptions, for the count methods.
+func (c *CrudRepository[ID, ENTITY]) countOptions() *options.CountOptions {
+	opts := c.newCountOptions()
+	if c.config.hint != nil {
+		errors.Check(validateHint(c.config.hint))
+		opts.SetHint(c.config.hint)
+	}
+	return opts
+}
+
+// activeFilter returns the predicate matching documents that are not soft-deleted.
+func (c *CrudRepository[ID, ENTITY]) activeFilter() bson.M {
+	if c.config.softDeleteActiveFilter != nil {
+		return c.config.softDeleteActiveFilter()
+	}
+	if c.config.softDeleteFlagField != "" {
+		return bson.M{c.config.softDeleteFlagField: bson.M{"$in": bson.A{false, nil}}}
+	}
+	return bson.M{
+		"$or": bson.A{
+			bson.M{c.softDeleteField: c.config.softDeleteActiveValue},
+			bson.M{c.softDeleteField: bson.M{"$exists": false}},
+		},
+	}
+}
+
+// deletedFilter returns the predicate matching soft-deleted documents.
+func (c *CrudRepository[ID, ENTITY]) deletedFilter() bson.M {
+	if c.config.softDeleteActiveFilter != nil {
+		return bson.M{"$nor": bson.A{c.config.softDeleteActiveFilter()}}
+	}
+	if c.config.softDeleteFlagField != "" {
+		return bson.M{c.config.softDeleteFlagField: true}
+	}
+	return bson.M{c.softDeleteField: bson.M{"$exists": true, "$ne": c.config.softDeleteActiveValue}}
+}
+
+// softDeletes reports whether the delete methods soft-delete rather than permanently delete the matched documents.
+func (c *CrudRepository[ID, ENTITY]) softDeletes() bool {
+	return c.softDeleteEnabled && !c.unscoped && !c.onlyDeleted
 }
 
 func (c *CrudRepository[ID, ENTITY]) IsUnscoped() bool {
@@ -68,9 +395,48 @@ func (c *CrudRepository[ID, ENTITY]) IsUnscoped() bool {
 func (c *CrudRepository[ID, ENTITY]) Unscoped() contract.CrudRepository[ID, ENTITY] {
 	cc := c.clone()
 	cc.unscoped = true
+	cc.onlyDeleted = false
+	return cc
+}
+
+// Scoped returns a repository whose queries only match documents that are not soft-deleted,
+// the inverse of Unscoped, e.g. on a repository created with WithDefaultUnscoped.
+func (c *CrudRepository[ID, ENTITY]) Scoped() *CrudRepository[ID, ENTITY] {
+	cc := c.clone()
+	cc.unscoped = false
+	cc.onlyDeleted = false
+	return cc
+}
+
+// OnlyDeleted returns a repository whose queries only match soft-deleted documents.
+// Its delete methods permanently delete the matched soft-deleted documents, e.g. to empty a recycle bin.
+func (c *CrudRepository[ID, ENTITY]) OnlyDeleted() contract.CrudRepository[ID, ENTITY] {
+	cc := c.clone()
+	cc.onlyDeleted = true
 	return cc
 }
 
+// With returns a repository with the options applied on top of the current ones, e.g. for a single call.
+// It panics with ErrInvalidArgument on an option that only takes effect in NewCrudRepository, such as WithIDField,
+// rather than return a repository differing from the one configured.
+func (c *CrudRepository[ID, ENTITY]) With(opts ...Option) *CrudRepository[ID, ENTITY] {
+	cc := c.clone()
+	for _, opt := range opts {
+		opt(&cc.config)
+	}
+	if !cc.config.sameConstruction(c.config) {
+		panic(ErrInvalidArgument.WrapStack(errors.NewWithMessage(
+			"WithIDField, WithRegistry, WithDiscriminator and WithDefaultUnscoped only take effect in NewCrudRepository")))
+	}
+	cc.readCollection = cc.newReadCollection()
+	return cc
+}
+
+// Collection returns the underlying collection, e.g. for custom queries in a repository embedding CrudRepository.
+func (c *CrudRepository[ID, ENTITY]) Collection() *mongo.Collection {
+	return c.collection
+}
+
 func (c *CrudRepository[ID, ENTITY]) IDField() string {
 	return c.idField
 }
@@ -83,14 +449,66 @@ func (c *CrudRepository[ID, ENTITY]) SoftDeleteEnabled() bool {
 	return c.softDeleteEnabled
 }
 
-func (c *CrudRepository[ID, ENTITY]) Create(ctx context.Context, entity ENTITY) (id ID, err error) {
+// DetectSoftDeleteType returns the kind of the DeletedAt field, through pointers: e.g. reflect.Int64 for unix
+// seconds, reflect.Struct for a time.Time and reflect.Bool for a flag, or reflect.Invalid if soft delete is
+// disabled. Soft delete sets a date field to the current time, a bool field to true and any other to unix seconds.
+func (c *CrudRepository[ID, ENTITY]) DetectSoftDeleteType() reflect.Kind {
+	if !c.softDeleteEnabled || c.softDeleteType == nil {
+		return reflect.Invalid
+	}
+	t := c.softDeleteType
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind()
+}
+
+// ValidateSoftDeleteConfig checks, e.g. at startup, that the stored documents use the soft delete field
+// resolved from ENTITY. It returns ErrSoftDeleteMismatch if the collection has documents but none has the field.
+func (c *CrudRepository[ID, ENTITY]) ValidateSoftDeleteConfig(ctx context.Context) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	result, err := c.collection.InsertOne(ctx, entity)
-	if err != nil && mongo.IsDuplicateKeyError(err) {
-		err = repository.ErrDuplicatedKey.WrapStack(err)
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	if !c.softDeleteEnabled {
+		return
+	}
+	opts := c.newFindOneOptions().SetProjection(bson.D{{Key: c.idField, Value: 1}})
+	err = c.collection.FindOne(ctx, bson.M{c.softDeleteField: bson.M{"$exists": true}}, opts).Err()
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		errors.Check(mapError(err))
+		return
 	}
+	err = c.collection.FindOne(ctx, bson.M{}, opts).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = nil
+		return
+	}
+	errors.Check(mapError(err))
+	errors.Check(ErrSoftDeleteMismatch.WrapStack(errors.NewWithMessage("field: %s, collection: %s", c.softDeleteField, c.collection.Name())))
+	return
+}
 
-	errors.Check(errors.WithStack(err))
+// Create inserts entity and returns its id, setting it on entity if it was generated.
+// The driver only generates `_id`, so with WithIDField set to another field a zero id fails with ErrInvalidArgument.
+// ENTITY may also be a struct value, e.g. User with value receivers rather than *User, whose SetID cannot
+// modify the caller's copy, so a generated id is only returned.
+func (c *CrudRepository[ID, ENTITY]) Create(ctx context.Context, entity ENTITY) (id ID, err error) {
+	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if isNil(entity) {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("entity is nil")))
+	}
+	var zero ID
+	if c.requireID() && entity.GetID() == zero {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("id is zero")))
+	}
+	result, err := c.collection.InsertOne(ctx, c.document(entity))
+	errors.Check(mapError(err))
+	if id = entity.GetID(); id != zero {
+		return
+	}
+	// the id was generated by the driver or server
 	id, ok := result.InsertedID.(ID)
 	if !ok {
 		errors.Check(errors.NewWithStack("unexpected type: %T", result.InsertedID))
@@ -99,63 +517,265 @@ func (c *CrudRepository[ID, ENTITY]) Create(ctx context.Context, entity ENTITY)
 	return
 }
 
+// CreateReturning is like Create, and returns the document as stored, read back by id, so that values applied
+// on insert, such as a generated id or the soft delete active value on a value entity, are populated.
+func (c *CrudRepository[ID, ENTITY]) CreateReturning(ctx context.Context, entity ENTITY) (created ENTITY, err error) {
+	defer errors.Recover(func(e error) { err = e })
+	id, err := c.Create(ctx, entity)
+	errors.Check(err)
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	created, err = c.DecodeOne(c.collection.FindOne(ctx, c.buildScopedFilter(bson.M{c.idField: id}, nil), c.newFindOneOptions()))
+	errors.Check(errors.Wrap(err, "param: %v", id))
+	return
+}
+
+// requireID reports whether the create methods reject entities with a zero id: with WithRequireID, or when
+// the id field is not `_id`, which is the only one the driver generates.
+func (c *CrudRepository[ID, ENTITY]) requireID() bool {
+	return c.config.requireID || c.idField != "_id"
+}
+
+// document returns entity as the create methods write it, with a zero soft delete field set to the active value.
+// A pointer entity is modified in place; a value entity, which cannot be, is copied.
+func (c *CrudRepository[ID, ENTITY]) document(entity ENTITY) any {
+	if !c.softDeleteEnabled {
+		return entity
+	}
+	v := reflect.ValueOf(entity)
+	if v.Kind() != reflect.Struct {
+		setZeroDeletedAt(entity, c.config.softDeleteActiveValue)
+		return entity
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	setZeroDeletedAt(ptr.Interface(), c.config.softDeleteActiveValue)
+	return ptr.Interface()
+}
+
+// documentCopy is like document, but always copies entity instead of modifying it.
+func (c *CrudRepository[ID, ENTITY]) documentCopy(entity ENTITY) any {
+	if !c.softDeleteEnabled {
+		return entity
+	}
+	v := reflect.ValueOf(entity)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return entity
+		}
+		v = v.Elem()
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	setZeroDeletedAt(ptr.Interface(), c.config.softDeleteActiveValue)
+	return ptr.Interface()
+}
+
+// BatchCreate inserts entities with a single unordered InsertMany, so a failed write does not stop the others,
+// and returns the ids of the inserted entities in order. If some entities collide on a unique index,
+// the error matches ErrDuplicatedKey and DuplicateKeyIndices reports their positions in entities.
+func (c *CrudRepository[ID, ENTITY]) BatchCreate(ctx context.Context, entities []ENTITY) (ids []ID, err error) {
+	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if len(entities) == 0 {
+		return
+	}
+	var zero ID
+	documents := make([]any, 0, len(entities))
+	for _, entity := range entities {
+		if isNil(entity) {
+			errors.Check(ErrInvalidArgument.WrapStack(errors.New("entity is nil")))
+		}
+		if c.requireID() && entity.GetID() == zero {
+			errors.Check(ErrInvalidArgument.WrapStack(errors.New("id is zero")))
+		}
+		documents = append(documents, c.document(entity))
+	}
+
+	result, insertErr := c.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if result == nil {
+		errors.Check(mapError(insertErr))
+	}
+	var failed []int
+	var bulkErr mongo.BulkWriteException
+	if stderrors.As(insertErr, &bulkErr) {
+		failed = uslice.Map(bulkErr.WriteErrors, func(writeErr mongo.BulkWriteError) int { return writeErr.Index })
+	}
+	for i, entity := range entities {
+		if uslice.Contains(failed, i) {
+			continue
+		}
+		id := entity.GetID()
+		if id == zero {
+			// the id was generated by the driver
+			var ok bool
+			if id, ok = result.InsertedIDs[i].(ID); !ok {
+				errors.Check(errors.NewWithStack("unexpected type: %T", result.InsertedIDs[i]))
+			}
+			entity.SetID(id)
+		}
+		ids = append(ids, id)
+	}
+	errors.Check(mapError(insertErr))
+	return
+}
+
 func (c *CrudRepository[ID, ENTITY]) FindOne(ctx context.Context, filter map[string]any, orders ...contract.Order) (entity ENTITY, err error) {
 	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", filter, orders) })
-	opts := options.FindOne()
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	opts := c.findOneOptions()
 	if len(orders) > 0 {
 		opts.SetSort(OrdersToSort(orders))
 	}
-	err = c.collection.FindOne(ctx, c.buildFilter(filter), opts).Decode(&entity)
-	if err != nil && errors.Is(err, mongo.ErrNoDocuments) {
-		err = repository.ErrNotFound.WrapStack(err)
-	}
-	errors.Check(errors.WithStack(err))
+	entity, err = c.DecodeOne(c.readCollection.FindOne(ctx, c.buildFilter(filter), opts))
+	errors.Check(err)
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) FindByID(ctx context.Context, id ID) (entity ENTITY, err error) {
 	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", id) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	filter := c.buildFilter(bson.M{c.idField: id})
+	entity, err = c.DecodeOne(c.readCollection.FindOne(ctx, filter, c.findOneOptions()))
+	errors.Check(err)
+	return
+}
+
+// DecodeOne decodes the document of sr into an ENTITY, failing with ErrNotFound if there is none like FindOne,
+// for custom single document reads, e.g. in a repository embedding CrudRepository.
+func (c *CrudRepository[ID, ENTITY]) DecodeOne(sr *mongo.SingleResult) (entity ENTITY, err error) {
+	err = mapError(sr.Decode(&entity))
+	return
+}
+
+// FindRawByID is like FindByID, and returns the document undecoded, e.g. to forward it as is.
+func (c *CrudRepository[ID, ENTITY]) FindRawByID(ctx context.Context, id ID) (raw bson.Raw, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", id) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
 	filter := c.buildFilter(bson.M{c.idField: id})
-	err = c.collection.FindOne(ctx, filter).Decode(&entity)
-	if err != nil && errors.Is(err, mongo.ErrNoDocuments) {
-		err = repository.ErrNotFound.WrapStack(err)
+	raw, err = c.readCollection.FindOne(ctx, filter, c.findOneOptions()).Raw()
+	errors.Check(mapError(err))
+	return
+}
+
+// FindByIDWithDeleted finds the entity with the id whether or not it is soft-deleted, and reports whether it is.
+// The entity and the report come from the same read: the entity is looked up among the active documents, then,
+// if it is not one, among the deleted ones, so that only a deleted entity costs a second round trip.
+func (c *CrudRepository[ID, ENTITY]) FindByIDWithDeleted(ctx context.Context, id ID) (entity ENTITY, deleted bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", id) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	filter := bson.M{c.idField: id}
+	if !c.softDeleteEnabled {
+		entity, err = c.DecodeOne(c.readCollection.FindOne(ctx, c.buildScopedFilter(filter, nil), c.findOneOptions()))
+		errors.Check(err)
+		return
 	}
-	errors.Check(errors.WithStack(err))
+	entity, err = c.DecodeOne(c.readCollection.FindOne(ctx, c.buildScopedFilter(filter, c.activeFilter()), c.findOneOptions()))
+	if !errors.Is(err, repository.ErrNotFound) {
+		errors.Check(err)
+		return
+	}
+	entity, err = c.DecodeOne(c.readCollection.FindOne(ctx, c.buildScopedFilter(filter, c.deletedFilter()), c.findOneOptions()))
+	errors.Check(err)
+	deleted = true
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) FindByIDs(ctx context.Context, ids []ID) (collection contract.Collection[ID, ENTITY], err error) {
 	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", ids) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
 	var entities []ENTITY
 	if len(ids) == 0 {
 		collection = repository.NewCollection[ID](entities)
 		return
 	}
 
-	filter := c.buildFilter(bson.M{c.idField: bson.M{"$in": ids}})
-	cursor, err := c.collection.Find(ctx, filter)
-	errors.Check(errors.WithStack(err))
-	err = cursor.All(ctx, &entities)
-	errors.Check(errors.WithStack(err))
+	opts := c.findOptions()
+	var mu sync.Mutex
+	err = c.forEachIDBatch(ctx, ids, func(ctx context.Context, batch []ID) error {
+		filter := c.buildFilter(bson.M{c.idField: bson.M{"$in": batch}})
+		cursor, err := c.collection.Find(ctx, filter, opts)
+		if err != nil {
+			return mapError(err)
+		}
+		var batchEntities []ENTITY
+		if err = cursor.All(ctx, &batchEntities); err != nil {
+			return mapError(err)
+		}
+		mu.Lock()
+		entities = append(entities, batchEntities...)
+		mu.Unlock()
+		return nil
+	})
+	errors.Check(err)
 
 	collection = repository.NewCollection[ID](entities)
 	return
 }
 
+// FindDictByIDs is like FindByIDs, and returns the found entities keyed by id along with the ids not found,
+// in the order of ids and without duplicates.
+func (c *CrudRepository[ID, ENTITY]) FindDictByIDs(ctx context.Context, ids []ID) (found contract.Dict[ID, ENTITY], missing []ID, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", ids) })
+	collection, err := c.FindByIDs(ctx, ids)
+	errors.Check(err)
+	found = collection.ToDict()
+	for _, id := range uslice.Unique(ids) {
+		if _, ok := found.Get(id); !ok {
+			missing = append(missing, id)
+		}
+	}
+	return
+}
+
 func (c *CrudRepository[ID, ENTITY]) FindByPage(ctx context.Context, limit, offset int, orders ...contract.Order) (collection contract.Collection[ID, ENTITY], err error) {
 	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", limit, offset, orders) })
-	opts := options.Find().SetSkip(int64(offset)).SetLimit(int64(limit))
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	opts := c.findOptions().SetSkip(int64(offset)).SetLimit(int64(limit))
 	if len(orders) > 0 {
 		opts.SetSort(OrdersToSort(orders))
 	}
 
 	filter := c.buildFilter(bson.M{})
 	cursor, err := c.collection.Find(ctx, filter, opts)
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
 
 	var entities []ENTITY
 	err = cursor.All(ctx, &entities)
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
+
+	collection = repository.NewCollection[ID](entities)
+	return
+}
+
+// FindByPageHasMore is like FindByPage, and reports whether more documents follow the page
+// by fetching one extra document.
+func (c *CrudRepository[ID, ENTITY]) FindByPageHasMore(ctx context.Context, limit, offset int, orders ...contract.Order) (collection contract.Collection[ID, ENTITY], hasMore bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", limit, offset, orders) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	opts := c.findOptions().SetSkip(int64(offset)).SetLimit(int64(limit + 1))
+	if len(orders) > 0 {
+		opts.SetSort(OrdersToSort(orders))
+	}
+
+	cursor, err := c.collection.Find(ctx, c.buildFilter(bson.M{}), opts)
+	errors.Check(mapError(err))
+
+	var entities []ENTITY
+	err = cursor.All(ctx, &entities)
+	errors.Check(mapError(err))
+	if len(entities) > limit {
+		hasMore = true
+		entities = entities[:limit]
+	}
 
 	collection = repository.NewCollection[ID](entities)
 	return
@@ -163,13 +783,87 @@ func (c *CrudRepository[ID, ENTITY]) FindByPage(ctx context.Context, limit, offs
 
 func (c *CrudRepository[ID, ENTITY]) FindByFilter(ctx context.Context, filter map[string]any) (collection contract.Collection[ID, ENTITY], err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+
+	cursor, err := c.collection.Find(ctx, c.buildFilter(filter), c.unboundedFindOptions())
+	errors.Check(mapError(err))
+
+	var entities []ENTITY
+	err = cursor.All(ctx, &entities)
+	errors.Check(mapError(err))
+	errors.Check(c.checkMaxResults(len(entities)))
+
+	collection = repository.NewCollection[ID](entities)
+	return
+}
+
+// FindByFilterWithOptions is like FindByFilter, with opts applied over the repository's find options.
+// The filter is still scoped by soft delete. WithMaxResults only applies if opts sets no limit.
+func (c *CrudRepository[ID, ENTITY]) FindByFilterWithOptions(ctx context.Context, filter map[string]any, opts *options.FindOptions) (collection contract.Collection[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	cursor, err := c.collection.Find(ctx, c.buildFilter(filter), c.unboundedFindOptions(), opts)
+	errors.Check(mapError(err))
+
+	var entities []ENTITY
+	err = cursor.All(ctx, &entities)
+	errors.Check(mapError(err))
+	if opts == nil || opts.Limit == nil {
+		errors.Check(c.checkMaxResults(len(entities)))
+	}
+
+	collection = repository.NewCollection[ID](entities)
+	return
+}
+
+// FindByFilterDict is like FindByFilter, and returns the entities keyed by id.
+func (c *CrudRepository[ID, ENTITY]) FindByFilterDict(ctx context.Context, filter map[string]any) (dict contract.Dict[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	cursor, err := c.collection.Find(ctx, c.buildFilter(filter), c.unboundedFindOptions())
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	dict = repository.NewDictWithSize[ID, ENTITY](cursor.RemainingBatchLength())
+	n := 0
+	for cursor.Next(ctx) {
+		n++
+		errors.Check(c.checkMaxResults(n))
+		var entity ENTITY
+		errors.Check(mapError(cursor.Decode(&entity)))
+		dict.Set(entity.GetID(), entity)
+	}
+	errors.Check(mapError(cursor.Err()))
+	return
+}
+
+// FindByFilterIncludeDeleted is like FindByFilter with orders, and also returns soft-deleted documents
+// if includeDeleted is true, without cloning the repository as Unscoped does.
+func (c *CrudRepository[ID, ENTITY]) FindByFilterIncludeDeleted(ctx context.Context, filter map[string]any, includeDeleted bool, orders ...contract.Order) (collection contract.Collection[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", filter, includeDeleted, orders) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	opts := c.unboundedFindOptions()
+	if len(orders) > 0 {
+		opts.SetSort(OrdersToSort(orders))
+	}
+	var query bson.D
+	if includeDeleted {
+		query = c.buildScopedFilter(filter, nil)
+	} else {
+		query = c.buildFilter(filter)
+	}
 
-	cursor, err := c.collection.Find(ctx, c.buildFilter(filter))
-	errors.Check(errors.WithStack(err))
+	cursor, err := c.collection.Find(ctx, query, opts)
+	errors.Check(mapError(err))
 
 	var entities []ENTITY
 	err = cursor.All(ctx, &entities)
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
+	errors.Check(c.checkMaxResults(len(entities)))
 
 	collection = repository.NewCollection[ID](entities)
 	return
@@ -177,18 +871,20 @@ func (c *CrudRepository[ID, ENTITY]) FindByFilter(ctx context.Context, filter ma
 
 func (c *CrudRepository[ID, ENTITY]) FindByFilterWithPage(ctx context.Context, filter map[string]any, limit, offset int, orders ...contract.Order) (collection contract.Collection[ID, ENTITY], err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
 
-	opts := options.Find().SetSkip(int64(offset)).SetLimit(int64(limit))
+	opts := c.findOptions().SetSkip(int64(offset)).SetLimit(int64(limit))
 	if len(orders) > 0 {
 		opts.SetSort(OrdersToSort(orders))
 	}
 
 	cursor, err := c.collection.Find(ctx, c.buildFilter(filter), opts)
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
 
 	var entities []ENTITY
 	err = cursor.All(ctx, &entities)
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
 
 	collection = repository.NewCollection[ID](entities)
 	return
@@ -196,183 +892,967 @@ func (c *CrudRepository[ID, ENTITY]) FindByFilterWithPage(ctx context.Context, f
 
 func (c *CrudRepository[ID, ENTITY]) FindAll(ctx context.Context) (collection contract.Collection[ID, ENTITY], err error) {
 	defer errors.Recover(func(e error) { err = e })
-	cursor, err := c.collection.Find(ctx, c.buildFilter(bson.M{}))
-	errors.Check(errors.WithStack(err))
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	cursor, err := c.collection.Find(ctx, c.buildFilter(bson.M{}), c.unboundedFindOptions())
+	errors.Check(mapError(err))
+
+	var entities []ENTITY
+	err = cursor.All(ctx, &entities)
+	errors.Check(mapError(err))
+	errors.Check(c.checkMaxResults(len(entities)))
+
+	collection = repository.NewCollection[ID](entities)
+	return
+}
+
+// FindAllOrderedByID is like FindAll, sorting by id in ascending order if asc is true and descending otherwise.
+func (c *CrudRepository[ID, ENTITY]) FindAllOrderedByID(ctx context.Context, asc bool) (collection contract.Collection[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", asc) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	direction := -1
+	if asc {
+		direction = 1
+	}
+	opts := c.unboundedFindOptions().SetSort(bson.D{{Key: c.idField, Value: direction}})
+	cursor, err := c.collection.Find(ctx, c.buildFilter(bson.M{}), opts)
+	errors.Check(mapError(err))
 
 	var entities []ENTITY
 	err = cursor.All(ctx, &entities)
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
+	errors.Check(c.checkMaxResults(len(entities)))
 
 	collection = repository.NewCollection[ID](entities)
 	return
 }
 
+// MinID returns the smallest id, found is false if there are no documents.
+func (c *CrudRepository[ID, ENTITY]) MinID(ctx context.Context) (id ID, found bool, err error) {
+	return c.findID(ctx, bson.M{}, bson.D{{Key: c.idField, Value: 1}})
+}
+
+// MaxID returns the largest id, found is false if there are no documents.
+func (c *CrudRepository[ID, ENTITY]) MaxID(ctx context.Context) (id ID, found bool, err error) {
+	return c.findID(ctx, bson.M{}, bson.D{{Key: c.idField, Value: -1}})
+}
+
+// FindIDByFilter returns the id of a matched document, found is false if there is none.
+func (c *CrudRepository[ID, ENTITY]) FindIDByFilter(ctx context.Context, filter map[string]any) (id ID, found bool, err error) {
+	return c.findID(ctx, filter, nil)
+}
+
+func (c *CrudRepository[ID, ENTITY]) findID(ctx context.Context, filter map[string]any, sort bson.D) (id ID, found bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", filter, sort) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	opts := c.newFindOneOptions().SetProjection(c.decodableProjection(bson.D{{Key: c.idField, Value: 1}}))
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	var entity ENTITY
+	err = c.collection.FindOne(ctx, c.buildFilter(filter), opts).Decode(&entity)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return id, false, nil
+	}
+	errors.Check(mapError(err))
+	return entity.GetID(), true, nil
+}
+
+// Count counts the documents, using the index of WithHint if any, e.g. userRepository.With(WithHint("name_1")).
 func (c *CrudRepository[ID, ENTITY]) Count(ctx context.Context) (count int, err error) {
 	defer errors.Recover(func(e error) { err = e })
-	cnt, err := c.collection.CountDocuments(ctx, c.buildFilter(bson.M{}))
-	errors.Check(errors.WithStack(err))
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	cnt, err := c.collection.CountDocuments(ctx, c.buildFilter(bson.M{}), c.countOptions())
+	errors.Check(mapError(err))
 	count = int(cnt)
 	return
 }
 
+// CountByFilter counts the matched documents, using the index of WithHint if any.
 func (c *CrudRepository[ID, ENTITY]) CountByFilter(ctx context.Context, filter map[string]any) (count int, err error) {
 	defer errors.Recover(func(e error) { err = e })
-	cnt, err := c.collection.CountDocuments(ctx, c.buildFilter(filter))
-	errors.Check(errors.WithStack(err))
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	cnt, err := c.collection.CountDocuments(ctx, c.buildFilter(filter), c.countOptions())
+	errors.Check(mapError(err))
 	count = int(cnt)
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) Exists(ctx context.Context, filter map[string]any) (exists bool, err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
 
-	opts := options.FindOne().SetProjection(bson.D{{c.idField, 1}})
+	opts := c.newFindOneOptions().SetProjection(c.existsProjection(filter))
 	err = c.collection.FindOne(ctx, c.buildFilter(filter), opts).Err()
 	if errors.Is(err, mongo.ErrNoDocuments) {
 		return false, nil
 	}
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
 	return true, nil
 }
 
+// existsProjection returns a projection that allows a covered query: a filtered field without `_id`
+// when the filter has one, so an index on that field can answer Exists, or else the id field.
+func (c *CrudRepository[ID, ENTITY]) existsProjection(filter map[string]any) bson.D {
+	keys := make([]string, 0, len(filter))
+	umap.Foreach(filter, func(k string, v any) {
+		if k != c.idField && !strings.HasPrefix(k, "$") {
+			keys = append(keys, k)
+		}
+	})
+	if len(keys) == 0 {
+		return bson.D{{Key: c.idField, Value: 1}}
+	}
+	sort.Strings(keys)
+	return bson.D{{Key: "_id", Value: 0}, {Key: keys[0], Value: 1}}
+}
+
 func (c *CrudRepository[ID, ENTITY]) ExistsByID(ctx context.Context, id ID) (exists bool, err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
 	filter := c.buildFilter(bson.M{c.idField: id})
-	opts := options.FindOne().SetProjection(bson.D{{c.idField, 1}})
+	opts := c.newFindOneOptions().SetProjection(bson.D{{Key: c.idField, Value: 1}})
 	err = c.collection.FindOne(ctx, filter, opts).Err()
 	if errors.Is(err, mongo.ErrNoDocuments) {
 		return false, nil
 	}
-	errors.Check(errors.WithStack(err))
+	errors.Check(mapError(err))
 	return true, nil
 }
 
 func (c *CrudRepository[ID, ENTITY]) ExistsByIDs(ctx context.Context, ids []ID) (exists contract.Dict[ID, bool], err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
 	if len(ids) == 0 {
 		exists = repository.NewDict[ID, bool](nil)
 		return
 	}
 
-	filter := c.buildFilter(bson.M{c.idField: bson.M{"$in": ids}})
-	opts := options.Find().SetProjection(bson.D{{c.idField, 1}})
-	cursor, err := c.collection.Find(ctx, filter, opts)
-	errors.Check(errors.WithStack(err))
+	exists = repository.NewDictWithSize[ID, bool](len(ids))
+	var mu sync.Mutex
+	projection := bson.D{{Key: c.idField, Value: 1}}
+	if c.config.existsByIDsProjection != nil {
+		projection = c.config.existsByIDsProjection
+		if len(uslice.Filter(projection, func(e bson.E) bool { return e.Key == c.idField && isIncluded(e.Value) })) == 0 {
+			errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("projection %v does not include %s", projection, c.idField)))
+		}
+	}
+	opts := c.newFindOptions().SetProjection(c.decodableProjection(projection))
+	err = c.forEachIDBatch(ctx, ids, func(ctx context.Context, batch []ID) error {
+		filter := c.buildFilter(bson.M{c.idField: bson.M{"$in": batch}})
+		cursor, err := c.collection.Find(ctx, filter, opts)
+		if err != nil {
+			return mapError(err)
+		}
+		var entities []ENTITY
+		if err = cursor.All(ctx, &entities); err != nil {
+			return mapError(err)
+		}
+		mu.Lock()
+		uslice.ForEach(entities, func(item ENTITY) {
+			exists.Set(item.GetID(), true)
+		})
+		mu.Unlock()
+		return nil
+	})
+	errors.Check(err)
 
-	var entities []ENTITY
-	err = cursor.All(ctx, &entities)
-	errors.Check(errors.WithStack(err))
+	return
+}
 
-	exists = repository.NewDictWithSize[ID, bool](len(entities))
-	uslice.ForEach(entities, func(item ENTITY) {
-		exists.Set(item.GetID(), true)
-	})
+// FindPartialByIDs is like ExistsByIDs, and returns the found entities keyed by id with only the id
+// and the given fields populated, saving a second query when a few fields are needed.
+func (c *CrudRepository[ID, ENTITY]) FindPartialByIDs(ctx context.Context, ids []ID, fields ...string) (dict contract.Dict[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", ids, fields) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	dict = repository.NewDictWithSize[ID, ENTITY](len(ids))
+	if len(ids) == 0 {
+		return
+	}
 
+	projection := bson.D{{Key: c.idField, Value: 1}}
+	for _, field := range fields {
+		if field != c.idField {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+	}
+	opts := c.newFindOptions().SetProjection(c.decodableProjection(projection))
+	var mu sync.Mutex
+	err = c.forEachIDBatch(ctx, ids, func(ctx context.Context, batch []ID) error {
+		filter := c.buildFilter(bson.M{c.idField: bson.M{"$in": batch}})
+		cursor, err := c.collection.Find(ctx, filter, opts)
+		if err != nil {
+			return mapError(err)
+		}
+		var entities []ENTITY
+		if err = cursor.All(ctx, &entities); err != nil {
+			return mapError(err)
+		}
+		mu.Lock()
+		uslice.ForEach(entities, func(item ENTITY) {
+			dict.Set(item.GetID(), item)
+		})
+		mu.Unlock()
+		return nil
+	})
+	errors.Check(err)
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) Update(ctx context.Context, filter map[string]any, data map[string]any) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	_, err = c.collection.UpdateMany(ctx, c.buildFilter(filter), bson.M{"$set": data})
-	errors.Check(errors.WithStack(err))
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	errors.Check(c.guardEmptyFilter(filter))
+	errors.Check(c.update(ctx, filter, data))
+	return
+}
+
+// UpdateAll sets data on every document, like Update with an empty filter, and returns the number matched.
+func (c *CrudRepository[ID, ENTITY]) UpdateAll(ctx context.Context, data map[string]any) (matched int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", data) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	result, err := c.collection.UpdateMany(ctx, c.buildFilter(bson.M{}), bson.M{"$set": c.updateData(data)}, c.newUpdateOptions())
+	errors.Check(mapError(err))
+	matched = result.MatchedCount
+	return
+}
+
+func (c *CrudRepository[ID, ENTITY]) update(ctx context.Context, filter map[string]any, data map[string]any) (err error) {
+	defer errors.Recover(func(e error) { err = e })
+	_, err = c.collection.UpdateMany(ctx, c.buildFilter(filter), bson.M{"$set": c.updateData(data)}, c.newUpdateOptions())
+	errors.Check(mapError(err))
 	return
 }
 
+// guardEmptyFilter rejects the empty filter of a method updating or deleting all matches,
+// if WithEmptyFilterGuard is enabled.
+func (c *CrudRepository[ID, ENTITY]) guardEmptyFilter(filter map[string]any) error {
+	if c.config.emptyFilterGuard && len(filter) == 0 {
+		return ErrInvalidArgument.WrapStack(errors.New("empty filter"))
+	}
+	return nil
+}
+
+// updateData returns the data of an update method without `_id`, which cannot be changed. Unless
+// WithStripIDOnUpdate is enabled, it fails with ErrInvalidArgument if data has the field instead.
+// It panics on an error, so it must be called under errors.Recover.
+func (c *CrudRepository[ID, ENTITY]) updateData(data map[string]any) map[string]any {
+	if _, ok := data["_id"]; !ok {
+		return data
+	}
+	if !c.config.stripIDOnUpdate {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("cannot update the _id field")))
+	}
+	stripped := make(map[string]any, len(data)-1)
+	umap.Foreach(data, func(k string, v any) {
+		if k != "_id" {
+			stripped[k] = v
+		}
+	})
+	return stripped
+}
+
+// nonZeroUpdateData returns the non-zero fields of entity as the data of an update method. The `_id` of entity,
+// which identifies the document rather than changes it, is left out.
+func (c *CrudRepository[ID, ENTITY]) nonZeroUpdateData(entity ENTITY) map[string]any {
+	data := getNonZeroFields(entity)
+	delete(data, "_id")
+	return data
+}
+
 func (c *CrudRepository[ID, ENTITY]) UpdateByID(ctx context.Context, id ID, data map[string]any) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	_, err = c.collection.UpdateOne(ctx, c.buildFilter(bson.M{c.idField: id}), bson.M{"$set": data})
-	errors.Check(errors.WithStack(err))
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	_, err = c.collection.UpdateOne(ctx, c.buildFilter(bson.M{c.idField: id}), bson.M{"$set": c.updateData(data)}, c.newUpdateOptions())
+	errors.Check(mapError(err))
 	return
 }
 
+// CompareAndSetByID sets field to newValue only if it currently equals expected, e.g. to move a state machine
+// from one state to the next, and reports whether it did. A nil expected also matches a missing field.
+func (c *CrudRepository[ID, ENTITY]) CompareAndSetByID(ctx context.Context, id ID, field string, expected, newValue any) (swapped bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v, %v", id, field, expected, newValue) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	filter := c.buildFilter(bson.M{c.idField: id, field: expected})
+	result, err := c.collection.UpdateOne(ctx, filter, bson.M{"$set": c.updateData(map[string]any{field: newValue})}, c.newUpdateOptions())
+	errors.Check(mapError(err))
+	swapped = result.MatchedCount > 0
+	return
+}
+
+// PatchByID applies patch with JSON Merge Patch (RFC 7386) semantics: a nil value removes the field,
+// a nested map[string]any or bson.M is merged into the field recursively if it holds an object, and replaces it
+// otherwise, and any other value replaces the field. The document is read first to tell objects apart; the update
+// only applies if they have not changed kind since, and is retried otherwise.
+func (c *CrudRepository[ID, ENTITY]) PatchByID(ctx context.Context, id ID, patch map[string]any) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", id, patch) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	patch = c.updateData(patch)
+	if len(patch) == 0 {
+		return
+	}
+	for {
+		current, err := c.collection.FindOne(ctx, c.buildFilter(bson.M{c.idField: id}), c.newFindOneOptions()).Raw()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		errors.Check(mapError(err))
+		filter := bson.M{c.idField: id}
+		set, unset := bson.M{}, bson.M{}
+		mergePatch(current, "", patch, set, unset, filter)
+		update := bson.M{}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		if len(update) == 0 {
+			return nil
+		}
+		result, err := c.collection.UpdateOne(ctx, c.buildFilter(filter), update, c.newUpdateOptions())
+		errors.Check(mapError(err))
+		if result.MatchedCount > 0 {
+			return nil
+		}
+	}
+}
+
+// mergePatch splits patch into the dotted paths to set and to unset, prefixed with prefix, given the current
+// document. It adds to filter whether each path a nested patch applies to holds an object, as it did in current.
+func mergePatch(current bson.Raw, prefix string, patch map[string]any, set, unset, filter bson.M) {
+	for k, v := range patch {
+		path := prefix + k
+		object, isObject := patchObject(v)
+		switch {
+		case v == nil:
+			unset[path] = ""
+		case !isObject:
+			set[path] = v
+		case current.Lookup(k).Type == bson.TypeEmbeddedDocument:
+			filter[path] = bson.M{"$type": "object"}
+			mergePatch(current.Lookup(k).Document(), path+".", object, set, unset, filter)
+		default:
+			filter[path] = bson.M{"$not": bson.M{"$type": "object"}}
+			set[path] = prunePatch(object)
+		}
+	}
+}
+
+// prunePatch returns the object patch applied to an empty object: without its nil values, recursively.
+func prunePatch(patch map[string]any) bson.M {
+	pruned := bson.M{}
+	for k, v := range patch {
+		if object, ok := patchObject(v); ok {
+			pruned[k] = prunePatch(object)
+		} else if v != nil {
+			pruned[k] = v
+		}
+	}
+	return pruned
+}
+
+// patchObject returns v as a map if it is a nested object of a merge patch.
+func patchObject(v any) (map[string]any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		return v, true
+	case bson.M:
+		return v, true
+	}
+	return nil, false
+}
+
+// UpdatePipelineByID is like UpdateByID, with an aggregation pipeline as the update, so that fields can be
+// computed from others, e.g. a `$set` stage of bson.M{"full_name": bson.M{"$concat": bson.A{"$first", " ", "$last"}}}.
+// It requires MongoDB 4.2 or later.
+func (c *CrudRepository[ID, ENTITY]) UpdatePipelineByID(ctx context.Context, id ID, pipeline mongo.Pipeline) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", id, pipeline) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if len(pipeline) == 0 {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("empty pipeline")))
+	}
+	_, err = c.collection.UpdateOne(ctx, c.buildFilter(bson.M{c.idField: id}), pipeline, c.newUpdateOptions())
+	errors.Check(mapError(err))
+	return
+}
+
+// UpdateByIDReturningOld is like UpdateByID, and returns the document as it was before the update.
+func (c *CrudRepository[ID, ENTITY]) UpdateByIDReturningOld(ctx context.Context, id ID, data map[string]any) (old ENTITY, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", id, data) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	opts := c.newFindOneAndUpdateOptions().SetReturnDocument(options.Before)
+	old, err = c.DecodeOne(c.collection.FindOneAndUpdate(ctx, c.buildFilter(bson.M{c.idField: id}), bson.M{"$set": c.updateData(data)}, opts))
+	errors.Check(err)
+	return
+}
+
+func (c *CrudRepository[ID, ENTITY]) UpdateByIDs(ctx context.Context, ids []ID, data map[string]any) (matched int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", ids, data) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if len(ids) == 0 {
+		return
+	}
+	filter := c.buildFilter(bson.M{c.idField: bson.M{"$in": ids}})
+	result, err := c.collection.UpdateMany(ctx, filter, bson.M{"$set": c.updateData(data)}, c.newUpdateOptions())
+	errors.Check(mapError(err))
+	matched = result.MatchedCount
+	return
+}
+
+// UpdateEach sets each change on the document with its id in a single unordered BulkWrite,
+// and returns the number of documents matched. Ids with no changes are skipped.
+func (c *CrudRepository[ID, ENTITY]) UpdateEach(ctx context.Context, changes map[ID]map[string]any) (matched int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", changes) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	models := make([]mongo.WriteModel, 0, len(changes))
+	for id, data := range changes {
+		data = c.updateData(data)
+		if len(data) == 0 {
+			continue
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(c.buildFilter(bson.M{c.idField: id})).
+			SetUpdate(bson.M{"$set": data}))
+	}
+	if len(models) == 0 {
+		return
+	}
+	result, err := c.collection.BulkWrite(ctx, models, c.newBulkWriteOptions().SetOrdered(false))
+	errors.Check(mapError(err))
+	matched = result.MatchedCount
+	return
+}
+
+// NonZeroFields returns the `$set` document UpdateNonZero and UpdateNonZeroByID write for entity,
+// e.g. to check why an update changed nothing. It is empty if every field of entity is zero.
+func (c *CrudRepository[ID, ENTITY]) NonZeroFields(entity ENTITY) bson.M {
+	return c.nonZeroUpdateData(entity)
+}
+
 func (c *CrudRepository[ID, ENTITY]) UpdateNonZero(ctx context.Context, filter map[string]any, entity ENTITY) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	data := getNonZeroFields(entity)
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	errors.Check(c.guardEmptyFilter(filter))
+	data := c.nonZeroUpdateData(entity)
 	if len(data) == 0 {
 		return
 	}
 
-	_, err = c.collection.UpdateMany(ctx, c.buildFilter(filter), bson.M{"$set": data})
-	errors.Check(errors.WithStack(err))
+	_, err = c.collection.UpdateMany(ctx, c.buildFilter(filter), bson.M{"$set": data}, c.newUpdateOptions())
+	errors.Check(mapError(err))
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) UpdateNonZeroByID(ctx context.Context, id ID, entity ENTITY) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	data := getNonZeroFields(entity)
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	data := c.nonZeroUpdateData(entity)
 	if len(data) == 0 {
 		return
 	}
 
-	_, err = c.collection.UpdateOne(ctx, c.buildFilter(bson.M{c.idField: id}), bson.M{"$set": data})
-	errors.Check(errors.WithStack(err))
+	_, err = c.collection.UpdateOne(ctx, c.buildFilter(bson.M{c.idField: id}), bson.M{"$set": data}, c.newUpdateOptions())
+	errors.Check(mapError(err))
 	return
 }
 
-func (c *CrudRepository[ID, ENTITY]) softDelete(ctx context.Context, filter map[string]any) (err error) {
+// UpsertNonZeroByID sets the non-zero fields of entity on the document with the id, creating it if absent.
+// A soft-deleted document with the id is not matched, so the insert fails with ErrDuplicatedKey.
+func (c *CrudRepository[ID, ENTITY]) UpsertNonZeroByID(ctx context.Context, id ID, entity ENTITY) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", id) })
+	data := c.nonZeroUpdateData(entity)
+	delete(data, c.idField)
+	errors.Check(c.upsert(ctx, bson.M{c.idField: id}, data, bson.M{c.idField: id}))
+	return
+}
+
+// Upsert sets data on the first document matching filter, creating it if absent.
+// The onInsert fields, e.g. a creation time, are only set when the document is created;
+// those also in data are ignored.
+func (c *CrudRepository[ID, ENTITY]) Upsert(ctx context.Context, filter map[string]any, data map[string]any, onInsert map[string]any) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", filter, data, onInsert) })
+	errors.Check(c.upsert(ctx, filter, data, onInsert))
+	return
+}
+
+// UpsertByID is like Upsert, for the document with the id.
+func (c *CrudRepository[ID, ENTITY]) UpsertByID(ctx context.Context, id ID, data map[string]any, onInsert map[string]any) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", id, data, onInsert) })
+	errors.Check(c.upsert(ctx, bson.M{c.idField: id}, data, onInsert))
+	return
+}
+
+// UpsertManyBy upserts entities matched on the value of their keyField, a natural key such as a unique name,
+// and returns how many documents were matched and created. Each matched document is replaced by its entity,
+// but keeps its id; a created document gets the entity id, unless it is zero. Of entities sharing a key value,
+// the last one wins. Entities are not modified.
+func (c *CrudRepository[ID, ENTITY]) UpsertManyBy(ctx context.Context, keyField string, entities []ENTITY) (matched, upserted int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", keyField) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if len(entities) == 0 {
+		return
+	}
+	var zero ID
+	path := strings.Split(keyField, ".")
+	keys := make([]bson.RawValue, 0, len(entities))
+	replacements := make(map[string]bson.D, len(entities))
+	ids := make(map[string]any, len(entities))
+	for _, entity := range entities {
+		raw, err := bson.MarshalWithRegistry(c.registry, c.documentCopy(entity))
+		errors.Check(errors.WithStack(err))
+		key, err := bson.Raw(raw).LookupErr(path...)
+		if err != nil {
+			errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("entity has no field %q", keyField)))
+		}
+		elements, err := bson.Raw(raw).Elements()
+		errors.Check(errors.WithStack(err))
+		replacement := make(bson.D, 0, len(elements))
+		for _, element := range elements {
+			if element.Key() != c.idField {
+				replacement = append(replacement, bson.E{Key: element.Key(), Value: element.Value()})
+			}
+		}
+		if _, ok := replacements[key.String()]; !ok {
+			keys = append(keys, key)
+		}
+		replacements[key.String()] = replacement
+		delete(ids, key.String())
+		if id := entity.GetID(); id != zero {
+			ids[key.String()] = id
+		}
+	}
+
+	// a replacement cannot change the id of the document it matches, so it takes the id of that document
+	opts := c.newFindOptions().SetProjection(bson.M{c.idField: 1, keyField: 1})
+	cursor, err := c.collection.Find(ctx, c.buildFilter(bson.M{keyField: bson.M{"$in": keys}}), opts)
+	errors.Check(mapError(err))
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		ids[cursor.Current.Lookup(path...).String()] = cursor.Current.Lookup(c.idField)
+	}
+	errors.Check(mapError(cursor.Err()))
+
+	models := make([]mongo.WriteModel, 0, len(keys))
+	for _, key := range keys {
+		replacement := replacements[key.String()]
+		if id, ok := ids[key.String()]; ok {
+			replacement = append(bson.D{{Key: c.idField, Value: id}}, replacement...)
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(c.buildFilter(bson.M{keyField: key})).
+			SetReplacement(replacement).
+			SetUpsert(true))
+	}
+	result, err := c.collection.BulkWrite(ctx, models, c.newBulkWriteOptions().SetOrdered(true))
+	errors.Check(mapError(err))
+	matched, upserted = result.MatchedCount, result.UpsertedCount
+	return
+}
+
+// upsert updates one document with `$set` data and `$setOnInsert` onInsert. A created document is active.
+func (c *CrudRepository[ID, ENTITY]) upsert(ctx context.Context, filter map[string]any, data map[string]any, onInsert map[string]any) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	err = c.Update(ctx, filter, bson.M{c.softDeleteField: time.Now().Unix()})
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	data = c.updateData(data)
+	setOnInsert := bson.M{}
+	umap.Foreach(onInsert, func(k string, v any) {
+		if _, ok := data[k]; !ok {
+			setOnInsert[k] = v
+		}
+	})
+	_, inData := data[c.softDeleteField]
+	if _, ok := setOnInsert[c.softDeleteField]; c.softDeleteEnabled && !inData && !ok {
+		setOnInsert[c.softDeleteField] = c.config.softDeleteActiveValue
+	}
+	update := bson.M{}
+	if len(data) > 0 {
+		update["$set"] = data
+	}
+	if len(setOnInsert) > 0 {
+		update["$setOnInsert"] = setOnInsert
+	}
+	if len(update) == 0 {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("nothing to upsert")))
+	}
+
+	opts := c.newUpdateOptions().SetUpsert(true)
+	_, err = c.collection.UpdateOne(ctx, c.buildFilter(filter), update, opts)
+	errors.Check(mapError(err))
+	return
+}
+
+// ClaimNext atomically sets claim on the first document matching filter in the given order and returns it
+// as updated, so concurrent workers never claim the same document. The filter should exclude claimed
+// documents. It returns ErrNotFound if no document matches.
+func (c *CrudRepository[ID, ENTITY]) ClaimNext(ctx context.Context, filter map[string]any, claim map[string]any, orders ...contract.Order) (entity ENTITY, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", filter, claim, orders) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	opts := c.newFindOneAndUpdateOptions().SetReturnDocument(options.After)
+	if len(orders) > 0 {
+		opts.SetSort(OrdersToSort(orders))
+	}
+	entity, err = c.DecodeOne(c.collection.FindOneAndUpdate(ctx, c.buildFilter(filter), bson.M{"$set": c.updateData(claim)}, opts))
 	errors.Check(err)
 	return
 }
 
+// FindOrCreate returns the document matching filter, or inserts entity and returns it with created set if there
+// is none, in a single upsert. Concurrent calls only insert one document if the filter fields have a unique index;
+// a call losing that race returns the winner's document.
+func (c *CrudRepository[ID, ENTITY]) FindOrCreate(ctx context.Context, filter map[string]any, entity ENTITY) (result ENTITY, created bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if isNil(entity) {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("entity is nil")))
+	}
+	opts := c.newFindOneAndUpdateOptions().SetUpsert(true).SetReturnDocument(options.Before)
+	err = c.collection.FindOneAndUpdate(ctx, c.buildFilter(filter), bson.M{"$setOnInsert": c.document(entity)}, opts).Decode(&result)
+	if mongo.IsDuplicateKeyError(err) {
+		// another call inserted the document first
+		result, err = c.FindOne(ctx, filter)
+		errors.Check(err)
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		errors.Check(mapError(err))
+		return
+	}
+
+	// nothing matched before the upsert, so entity was inserted
+	var zero ID
+	if entity.GetID() == zero {
+		// the id was generated by the server
+		result, err = c.FindOne(ctx, filter)
+		errors.Check(err)
+		return result, true, nil
+	}
+	return entity, true, nil
+}
+
+// ToggleByID atomically flips the boolean field of the document with the id, a missing field counting as false,
+// and returns its new value. It returns ErrNotFound if no document matches.
+func (c *CrudRepository[ID, ENTITY]) ToggleByID(ctx context.Context, id ID, field string) (value bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", id, field) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if field == "" || field == c.idField {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid toggle field: %q", field)))
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{field: bson.M{"$not": bson.A{"$" + field}}}}}}
+	opts := c.newFindOneAndUpdateOptions().SetReturnDocument(options.After)
+	raw, err := c.collection.FindOneAndUpdate(ctx, c.buildFilter(bson.M{c.idField: id}), update, opts).Raw()
+	errors.Check(mapError(err))
+	value, ok := raw.Lookup(strings.Split(field, ".")...).BooleanOK()
+	if !ok {
+		errors.Check(errors.NewWithStack("toggle field %q is not a boolean", field))
+	}
+	return
+}
+
+// CreateIfNotExists inserts entity if no document matches filter, in a single upsert, and reports whether it did.
+// Like FindOrCreate, concurrent calls only insert one document if the filter fields have a unique index.
+func (c *CrudRepository[ID, ENTITY]) CreateIfNotExists(ctx context.Context, filter map[string]any, entity ENTITY) (created bool, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if isNil(entity) {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("entity is nil")))
+	}
+	opts := c.newUpdateOptions().SetUpsert(true)
+	result, err := c.collection.UpdateOne(ctx, c.buildFilter(filter), bson.M{"$setOnInsert": c.document(entity)}, opts)
+	errors.Check(mapError(err))
+	if result.UpsertedID == nil {
+		return false, nil
+	}
+
+	var zero ID
+	if entity.GetID() == zero {
+		// the id was generated by the server
+		id, ok := result.UpsertedID.(ID)
+		if !ok {
+			errors.Check(errors.NewWithStack("unexpected type: %T", result.UpsertedID))
+		}
+		entity.SetID(id)
+	}
+	return true, nil
+}
+
+// NextSequence atomically increments field of the counter document with the id, creating it if absent,
+// and returns the new value, so the first call returns 1. It is meant for allocating sequential ids,
+// e.g. with a counters repository keyed by the collection name. Soft delete does not apply to counters.
+func (c *CrudRepository[ID, ENTITY]) NextSequence(ctx context.Context, id ID, field string) (value int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", id, field) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if field == "" || field == c.idField {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid sequence field: %q", field)))
+	}
+	opts := c.newFindOneAndUpdateOptions().SetUpsert(true).SetReturnDocument(options.After)
+	raw, err := c.collection.FindOneAndUpdate(ctx, c.buildScopedFilter(bson.M{c.idField: id}, nil), bson.M{"$inc": bson.M{field: int64(1)}}, opts).Raw()
+	errors.Check(mapError(err))
+	value, ok := raw.Lookup(strings.Split(field, ".")...).AsInt64OK()
+	if !ok {
+		errors.Check(errors.NewWithStack("sequence field %q is not an integer", field))
+	}
+	return
+}
+
+// softDeleteData returns the fields set on a soft-deleted document.
+func (c *CrudRepository[ID, ENTITY]) softDeleteData() bson.M {
+	if c.config.softDeleteUpdater != nil {
+		return c.config.softDeleteUpdater()
+	}
+	return c.withSoftDeleteFlag(bson.M{c.softDeleteField: c.softDeleteValue(time.Now(), 0)}, true)
+}
+
+// softDeleteValue returns the value of the soft delete field for a document deleted at now, moved by offset units
+// of the field, milliseconds for a date and seconds for unix seconds, so that ordered soft deletes stay distinct.
+func (c *CrudRepository[ID, ENTITY]) softDeleteValue(now time.Time, offset int) any {
+	switch {
+	case isDateType(c.softDeleteType):
+		return now.Add(time.Duration(offset) * time.Millisecond)
+	case c.DetectSoftDeleteType() == reflect.Bool:
+		return true
+	default:
+		return now.Unix() + int64(offset)
+	}
+}
+
+// withSoftDeleteFlag adds the flag field of WithSoftDeleteFlag with value to data, if configured.
+func (c *CrudRepository[ID, ENTITY]) withSoftDeleteFlag(data bson.M, value bool) bson.M {
+	if c.config.softDeleteFlagField != "" {
+		data[c.config.softDeleteFlagField] = value
+	}
+	return data
+}
+
+func (c *CrudRepository[ID, ENTITY]) softDelete(ctx context.Context, filter map[string]any) (err error) {
+	_, err = c.softDeleteCount(ctx, filter)
+	return
+}
+
+// softDeleteCount is like softDelete, and returns the number of documents soft-deleted.
+func (c *CrudRepository[ID, ENTITY]) softDeleteCount(ctx context.Context, filter map[string]any) (modified int64, err error) {
+	defer errors.Recover(func(e error) { err = e })
+	if c.config.softDeleteUpdater == nil && c.config.orderedSoftDelete {
+		modified, err = c.softDeleteOrdered(ctx, filter)
+		errors.Check(err)
+		return
+	}
+	result, err := c.collection.UpdateMany(ctx, c.buildFilter(filter), bson.M{"$set": c.softDeleteData()}, c.newUpdateOptions())
+	errors.Check(mapError(err))
+	modified = result.ModifiedCount
+	return
+}
+
+// orderedSoftDeleteSpan bounds how far past the deletion time WithOrderedSoftDelete stamps documents.
+const orderedSoftDeleteSpan = time.Minute
+
+func (c *CrudRepository[ID, ENTITY]) softDeleteOrdered(ctx context.Context, filter map[string]any) (modified int64, err error) {
+	defer errors.Recover(func(e error) { err = e })
+	opts := c.newFindOptions().
+		SetProjection(c.decodableProjection(bson.D{{Key: c.idField, Value: 1}})).
+		SetSort(bson.D{{Key: c.idField, Value: 1}})
+	cursor, err := c.collection.Find(ctx, c.buildFilter(filter), opts)
+	errors.Check(mapError(err))
+
+	var entities []ENTITY
+	err = cursor.All(ctx, &entities)
+	errors.Check(mapError(err))
+	if len(entities) == 0 {
+		return
+	}
+
+	// the stamps step forward from now by one unit of the field, so that a document never looks deleted earlier
+	// than it was, e.g. to PurgeDeletedBefore, and stay within orderedSoftDeleteSpan
+	unit := time.Second
+	if isDateType(c.softDeleteType) {
+		unit = time.Millisecond
+	}
+	if len(entities) > int(orderedSoftDeleteSpan/unit) {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage(
+			"%d documents do not get distinct stamps within %v of %v", len(entities), orderedSoftDeleteSpan, unit)))
+	}
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(entities))
+	for i, entity := range entities {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(c.buildFilter(bson.M{c.idField: entity.GetID()})).
+			SetUpdate(bson.M{"$set": c.withSoftDeleteFlag(bson.M{c.softDeleteField: c.softDeleteValue(now, i)}, true)}))
+	}
+	result, err := c.collection.BulkWrite(ctx, models, c.newBulkWriteOptions().SetOrdered(true))
+	errors.Check(mapError(err))
+	modified = result.ModifiedCount
+	return
+}
+
 func (c *CrudRepository[ID, ENTITY]) Delete(ctx context.Context, filter map[string]any) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	if c.softDeleteEnabled && !c.unscoped {
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	errors.Check(validateFilter(filter))
+	errors.Check(c.guardEmptyFilter(filter))
+	if c.softDeletes() {
 		errors.Check(c.softDelete(ctx, filter))
 		return
 	}
-	_, err = c.collection.DeleteMany(ctx, filter)
-	errors.Check(errors.WithStack(err))
+	_, err = c.collection.DeleteMany(ctx, c.buildFilter(filter), c.newDeleteOptions())
+	errors.Check(mapError(err))
+	return
+}
+
+// DeleteByFilterReturningCount is like Delete, and returns the number of documents deleted, e.g. for the logs of a
+// cleanup job: the modified count of a soft delete, which skips documents already deleted, or the deleted count.
+func (c *CrudRepository[ID, ENTITY]) DeleteByFilterReturningCount(ctx context.Context, filter map[string]any) (deleted int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	errors.Check(validateFilter(filter))
+	errors.Check(c.guardEmptyFilter(filter))
+	if c.softDeletes() {
+		deleted, err = c.softDeleteCount(ctx, filter)
+		errors.Check(err)
+		return
+	}
+	result, err := c.collection.DeleteMany(ctx, c.buildFilter(filter), c.newDeleteOptions())
+	errors.Check(mapError(err))
+	deleted = result.DeletedCount
+	return
+}
+
+// DeleteOne deletes a single document matching filter, unlike Delete which deletes all of them.
+func (c *CrudRepository[ID, ENTITY]) DeleteOne(ctx context.Context, filter map[string]any) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	errors.Check(validateFilter(filter))
+	errors.Check(c.guardEmptyFilter(filter))
+	if c.softDeletes() {
+		_, err = c.collection.UpdateOne(ctx, c.buildFilter(filter), bson.M{"$set": c.softDeleteData()}, c.newUpdateOptions())
+		errors.Check(mapError(err))
+		return
+	}
+	_, err = c.collection.DeleteOne(ctx, c.buildFilter(filter), c.newDeleteOptions())
+	errors.Check(mapError(err))
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) DeleteByID(ctx context.Context, id ID) (err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
 	filter := bson.M{c.idField: id}
-	if c.softDeleteEnabled && !c.unscoped {
+	if c.softDeletes() {
 		errors.Check(c.softDelete(ctx, filter))
 		return
 	}
-	_, err = c.collection.DeleteOne(ctx, filter)
-	errors.Check(errors.WithStack(err))
+	_, err = c.collection.DeleteOne(ctx, c.buildFilter(filter), c.newDeleteOptions())
+	errors.Check(mapError(err))
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) DeleteByIDs(ctx context.Context, ids []ID) (err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
 	if len(ids) == 0 {
 		return
 	}
 	filter := bson.M{c.idField: bson.M{"$in": ids}}
-	if c.softDeleteEnabled && !c.unscoped {
+	if c.softDeletes() {
 		errors.Check(c.softDelete(ctx, filter))
 		return
 	}
-	_, err = c.collection.DeleteMany(ctx, filter)
-	errors.Check(errors.WithStack(err))
+	_, err = c.collection.DeleteMany(ctx, c.buildFilter(filter), c.newDeleteOptions())
+	errors.Check(mapError(err))
+	return
+}
+
+// RestoreByFilter resets the soft delete field of the soft-deleted documents matching filter to the active value,
+// and returns how many were restored. Other fields set by WithSoftDeleteUpdater are left as they are.
+func (c *CrudRepository[ID, ENTITY]) RestoreByFilter(ctx context.Context, filter map[string]any) (restored int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if !c.softDeleteEnabled {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("soft delete is not enabled")))
+	}
+	update := bson.M{"$set": c.withSoftDeleteFlag(bson.M{c.softDeleteField: c.config.softDeleteActiveValue}, false)}
+	result, err := c.collection.UpdateMany(ctx, c.buildScopedFilter(filter, c.deletedFilter()), update, c.newUpdateOptions())
+	errors.Check(mapError(err))
+	restored = result.ModifiedCount
+	return
+}
+
+// PurgeDeletedBefore permanently deletes the documents soft-deleted before t, and returns how many were deleted.
+// It is the alternative to EnableSoftDeleteTTL for a soft delete field holding unix seconds.
+func (c *CrudRepository[ID, ENTITY]) PurgeDeletedBefore(ctx context.Context, t time.Time) (deleted int64, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", t) })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if !c.softDeleteEnabled {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("soft delete is not enabled")))
+	}
+	var before any = t.Unix()
+	if isDateType(c.softDeleteType) {
+		before = t
+	}
+	filter := c.buildScopedFilter(bson.M{c.softDeleteField: bson.M{"$lt": before}}, c.deletedFilter())
+	result, err := c.collection.DeleteMany(ctx, filter, c.newDeleteOptions())
+	errors.Check(mapError(err))
+	deleted = result.DeletedCount
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) DeleteAll(ctx context.Context) (err error) {
 	defer errors.Recover(func(e error) { err = e })
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
 	filter := bson.M{}
-	if c.softDeleteEnabled && !c.unscoped {
+	if c.softDeletes() {
 		errors.Check(c.softDelete(ctx, filter))
 		return
 	}
-	_, err = c.collection.DeleteMany(ctx, filter)
-	errors.Check(errors.WithStack(err))
+	_, err = c.collection.DeleteMany(ctx, c.buildFilter(filter), c.newDeleteOptions())
+	errors.Check(mapError(err))
 	return
 }
 
 func (c *CrudRepository[ID, ENTITY]) DeleteAllByFilter(ctx context.Context, filter map[string]any) (err error) {
 	defer errors.Recover(func(e error) { err = e })
-	if c.softDeleteEnabled && !c.unscoped {
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	errors.Check(validateFilter(filter))
+	errors.Check(c.guardEmptyFilter(filter))
+	if c.softDeletes() {
 		errors.Check(c.softDelete(ctx, filter))
 		return
 	}
-	_, err = c.collection.DeleteMany(ctx, filter)
-	errors.Check(errors.WithStack(err))
+	_, err = c.collection.DeleteMany(ctx, c.buildFilter(filter), c.newDeleteOptions())
+	errors.Check(mapError(err))
 	return
 }
@@ -0,0 +1,64 @@
+package repositorymongo
+
+import (
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"reflect"
+	"sort"
+)
+
+// sampleEntity returns a value of the first registered discriminator type, in key order,
+// from which the fields of an interface ENTITY are resolved. It returns nil if no type is registered.
+func (c config) sampleEntity() any {
+	keys := make([]string, 0, len(c.discriminatorTypes))
+	for key := range c.discriminatorTypes {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	return c.discriminatorTypes[keys[0]]()
+}
+
+// discriminatorRegistry returns a registry decoding the interface type t with the discriminator of c.
+func (c config) discriminatorRegistry(t reflect.Type) *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	registry.RegisterTypeDecoder(t, bsoncodec.ValueDecoderFunc(
+		func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+			if vr.Type() == bsontype.Null {
+				val.Set(reflect.Zero(val.Type()))
+				return vr.ReadNull()
+			}
+			raw, err := bsonrw.Copier{}.CopyDocumentToBytes(vr)
+			if err != nil {
+				return err
+			}
+			kind, ok := bson.Raw(raw).Lookup(c.discriminatorField).StringValueOK()
+			factory, found := c.discriminatorTypes[kind]
+			if !ok || !found {
+				return errors.NewWithStack("unknown %s: %s", c.discriminatorField, bson.Raw(raw).Lookup(c.discriminatorField))
+			}
+			value := factory()
+			if rv := reflect.ValueOf(value); rv.Kind() != reflect.Ptr || !rv.Type().AssignableTo(val.Type()) {
+				return errors.NewWithStack("%s: %T does not implement %s", kind, value, val.Type())
+			}
+			decoder, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(raw))
+			if err != nil {
+				return err
+			}
+			if err = decoder.SetRegistry(dc.Registry); err != nil {
+				return err
+			}
+			if err = decoder.Decode(value); err != nil {
+				return err
+			}
+			val.Set(reflect.ValueOf(value))
+			return nil
+		},
+	))
+	return registry
+}
@@ -0,0 +1,160 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository/contract"
+	"github.com/magiconair/properties/assert"
+	"log"
+	"testing"
+)
+
+type Shape interface {
+	contract.ENTITY[int64]
+	Area() float64
+}
+
+type Circle struct {
+	ID     int64   `json:"id" bson:"_id"`
+	Kind   string  `json:"kind" bson:"kind"`
+	Radius float64 `json:"radius" bson:"radius"`
+}
+
+func (c *Circle) GetID() int64 {
+	return c.ID
+}
+
+func (c *Circle) SetID(id int64) {
+	c.ID = id
+}
+
+func (c *Circle) Area() float64 {
+	return 3 * c.Radius * c.Radius
+}
+
+type Rect struct {
+	ID     int64   `json:"id" bson:"_id"`
+	Kind   string  `json:"kind" bson:"kind"`
+	Width  float64 `json:"width" bson:"width"`
+	Height float64 `json:"height" bson:"height"`
+}
+
+func (r *Rect) GetID() int64 {
+	return r.ID
+}
+
+func (r *Rect) SetID(id int64) {
+	r.ID = id
+}
+
+func (r *Rect) Area() float64 {
+	return r.Width * r.Height
+}
+
+type Square struct {
+	ID        int64   `json:"id" bson:"_id"`
+	Kind      string  `json:"kind" bson:"kind"`
+	Side      float64 `json:"side" bson:"side"`
+	DeletedAt int64   `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (s *Square) GetID() int64 {
+	return s.ID
+}
+
+func (s *Square) SetID(id int64) {
+	s.ID = id
+}
+
+func (s *Square) Area() float64 {
+	return s.Side * s.Side
+}
+
+func TestCrudRepository_WithDiscriminator(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithDiscriminator err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	shapeRepository := NewCrudRepository[int64, Shape](db.Collection("shape"), WithDiscriminator("kind", map[string]func() any{
+		"circle": func() any { return &Circle{} },
+		"rect":   func() any { return &Rect{} },
+	}))
+	assert.Equal(t, shapeRepository.IDField(), "_id")
+
+	circle := &Circle{ID: idGen.Generate(), Kind: "circle", Radius: 2}
+	rect := &Rect{ID: idGen.Generate(), Kind: "rect", Width: 2, Height: 3}
+	for _, shape := range []Shape{circle, rect} {
+		_, err := shapeRepository.Create(context.Background(), shape)
+		errors.Check(errors.Wrap(err, "failed to create shape"))
+	}
+
+	shape, err := shapeRepository.FindByID(context.Background(), circle.ID)
+	errors.Check(errors.Wrap(err, "failed to find shape"))
+	assert.Equal(t, shape, Shape(circle))
+
+	collection, err := shapeRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find shapes"))
+	assert.Equal(t, collection.All(), []Shape{circle, rect})
+	assert.Equal(t, collection.All()[1].Area(), float64(6))
+
+	_, err = db.Collection("shape").InsertOne(context.Background(), map[string]any{"_id": idGen.Generate(), "kind": "triangle"})
+	errors.Check(errors.Wrap(err, "failed to insert shape"))
+	_, err = shapeRepository.FindAll(context.Background())
+	assert.Equal(t, err != nil, true)
+
+	// without any type to resolve the fields from, the repository cannot be created
+	err = func() (err error) {
+		defer errors.Recover(func(e error) { err = e })
+		NewCrudRepository[int64, Shape](db.Collection("shape"), WithDiscriminator("kind", map[string]func() any{}))
+		return
+	}()
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_WithDiscriminator_IDProjection(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithDiscriminator_IDProjection err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	shapeRepository := NewCrudRepository[int64, Shape](db.Collection("shape"), WithDiscriminator("kind", map[string]func() any{
+		"circle": func() any { return &Circle{} },
+		"rect":   func() any { return &Rect{} },
+	}))
+	circle := &Circle{ID: idGen.Generate(), Kind: "circle", Radius: 2}
+	rect := &Rect{ID: idGen.Generate(), Kind: "rect", Width: 2, Height: 3}
+	for _, shape := range []Shape{circle, rect} {
+		_, err := shapeRepository.Create(context.Background(), shape)
+		errors.Check(errors.Wrap(err, "failed to create shape"))
+	}
+
+	exists, err := shapeRepository.ExistsByIDs(context.Background(), []int64{circle.ID, rect.ID})
+	errors.Check(errors.Wrap(err, "failed to check shapes"))
+	for _, id := range []int64{circle.ID, rect.ID} {
+		got, _ := exists.Get(id)
+		assert.Equal(t, got, true)
+	}
+	id, found, err := shapeRepository.MinID(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find min id"))
+	assert.Equal(t, found, true)
+	assert.Equal(t, id, circle.ID)
+	id, _, err = shapeRepository.MaxID(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find max id"))
+	assert.Equal(t, id, rect.ID)
+	id, _, err = shapeRepository.FindIDByFilter(context.Background(), map[string]any{"kind": "rect"})
+	errors.Check(errors.Wrap(err, "failed to find id"))
+	assert.Equal(t, id, rect.ID)
+	dict, err := shapeRepository.FindPartialByIDs(context.Background(), []int64{circle.ID}, "radius")
+	errors.Check(errors.Wrap(err, "failed to find shapes"))
+	shape, _ := dict.Get(circle.ID)
+	assert.Equal(t, shape, Shape(circle))
+
+	squareRepository := NewCrudRepository[int64, Shape](db.Collection("square"), WithOrderedSoftDelete(true), WithDiscriminator("kind", map[string]func() any{
+		"square": func() any { return &Square{} },
+	}))
+	square := &Square{ID: idGen.Generate(), Kind: "square", Side: 2}
+	_, err = squareRepository.Create(context.Background(), square)
+	errors.Check(errors.Wrap(err, "failed to create shape"))
+	err = squareRepository.DeleteByID(context.Background(), square.ID)
+	errors.Check(errors.Wrap(err, "failed to delete shape"))
+	exists2, err := squareRepository.ExistsByID(context.Background(), square.ID)
+	errors.Check(errors.Wrap(err, "failed to check shape"))
+	assert.Equal(t, exists2, false)
+}
@@ -0,0 +1,89 @@
+package repositorymongo
+
+import (
+	stderrors "errors"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sort"
+)
+
+var (
+	ErrUnavailable = errors.NewWithMessage("repository: database unavailable")
+	ErrNotCapped   = errors.NewWithMessage("repository: collection is not capped")
+
+	ErrReplicaSetRequired = errors.NewWithMessage("repository: operation requires a replica set")
+	ErrInvalidArgument    = errors.NewWithMessage("repository: invalid argument")
+	ErrSoftDeleteMismatch = errors.NewWithMessage("repository: soft delete field not found in stored documents")
+	ErrTextIndexRequired  = errors.NewWithMessage("repository: text index required")
+	ErrValidation         = errors.NewWithMessage("repository: document failed validation")
+	ErrTooManyResults     = errors.NewWithMessage("repository: too many results")
+)
+
+const (
+	// codeChangeStreamNotSupported is returned by a standalone server for `$changeStream`.
+	codeChangeStreamNotSupported = 40573
+	// codeIllegalOperation is returned by a standalone server for a transaction, among other cases.
+	codeIllegalOperation = 20
+	// codeNotAReplicaSet is returned by a standalone server for a linearizable read concern.
+	codeNotAReplicaSet = 123
+	// codeNamespaceExists is returned when creating a collection that already exists.
+	codeNamespaceExists = 48
+	// codeDocumentValidationFailure is returned for a write rejected by the collection validator.
+	codeDocumentValidationFailure = 121
+)
+
+// mapError translates driver errors into the repository errors callers can match with errors.Is.
+func mapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case mongo.IsDuplicateKeyError(err):
+		return repository.ErrDuplicatedKey.WrapStack(err)
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return repository.ErrNotFound.WrapStack(err)
+	case errors.Is(err, mongo.ErrClientDisconnected):
+		return ErrUnavailable.WrapStack(err)
+	case hasErrorCode(err, codeChangeStreamNotSupported):
+		return ErrReplicaSetRequired.WrapStack(err)
+	case hasErrorCodeWithMessage(err, codeIllegalOperation, "replica set"):
+		return ErrReplicaSetRequired.WrapStack(err)
+	case hasErrorCode(err, codeNotAReplicaSet):
+		return ErrReplicaSetRequired.WrapStack(err)
+	case hasErrorCode(err, codeDocumentValidationFailure):
+		return ErrValidation.WrapStack(err)
+	}
+	return errors.WithStack(err)
+}
+
+// DuplicateKeyIndices returns the indices of the writes of a bulk operation, such as BatchCreate,
+// that failed with a duplicate key, in ascending order. It returns nil if err holds no bulk write errors.
+func DuplicateKeyIndices(err error) []int {
+	var bulkErr mongo.BulkWriteException
+	if !stderrors.As(err, &bulkErr) {
+		return nil
+	}
+	var indices []int
+	for _, writeErr := range bulkErr.WriteErrors {
+		if mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			indices = append(indices, writeErr.Index)
+		}
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+func hasErrorCode(err error, code int) bool {
+	var serverErr mongo.ServerError
+	return stderrors.As(err, &serverErr) && serverErr.HasErrorCode(code)
+}
+
+func hasErrorCodeWithMessage(err error, code int, message string) bool {
+	var serverErr mongo.ServerError
+	return stderrors.As(err, &serverErr) && serverErr.HasErrorCodeWithMessage(code, message)
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var labeledErr mongo.LabeledError
+	return stderrors.As(err, &labeledErr) && labeledErr.HasErrorLabel(label)
+}
@@ -0,0 +1,52 @@
+package repositorymongo
+
+import (
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+func TestMapError(t *testing.T) {
+	assert.Equal(t, mapError(nil), nil)
+
+	err := mapError(mongo.ErrClientDisconnected)
+	assert.Equal(t, errors.Is(err, ErrUnavailable), true)
+	assert.Equal(t, errors.Is(err, mongo.ErrClientDisconnected), true)
+
+	err = mapError(mongo.ErrNoDocuments)
+	assert.Equal(t, errors.Is(err, repository.ErrNotFound), true)
+
+	err = mapError(mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}})
+	assert.Equal(t, errors.Is(err, repository.ErrDuplicatedKey), true)
+
+	err = mapError(mongo.CommandError{Code: codeChangeStreamNotSupported})
+	assert.Equal(t, errors.Is(err, ErrReplicaSetRequired), true)
+
+	err = mapError(mongo.CommandError{Code: codeIllegalOperation, Message: "Transaction numbers are only allowed on a replica set member or mongos"})
+	assert.Equal(t, errors.Is(err, ErrReplicaSetRequired), true)
+
+	err = mapError(mongo.CommandError{Code: codeNotAReplicaSet, Message: "node needs to be a replica set member to use read concern"})
+	assert.Equal(t, errors.Is(err, ErrReplicaSetRequired), true)
+
+	err = mapError(mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: codeDocumentValidationFailure, Message: "Document failed validation"}}})
+	assert.Equal(t, errors.Is(err, ErrValidation), true)
+
+	err = mapError(mongo.ErrNilDocument)
+	assert.Equal(t, errors.Is(err, ErrUnavailable), false)
+	assert.Equal(t, errors.Is(err, mongo.ErrNilDocument), true)
+}
+
+func TestDuplicateKeyIndices(t *testing.T) {
+	assert.Equal(t, DuplicateKeyIndices(nil), []int(nil))
+	assert.Equal(t, DuplicateKeyIndices(mongo.ErrNoDocuments), []int(nil))
+
+	err := mapError(mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 3, Code: 11000}},
+		{WriteError: mongo.WriteError{Index: 1, Code: 11000}},
+		{WriteError: mongo.WriteError{Index: 2, Code: 121}},
+	}})
+	assert.Equal(t, errors.Is(err, repository.ErrDuplicatedKey), true)
+	assert.Equal(t, DuplicateKeyIndices(err), []int{1, 3})
+}
@@ -0,0 +1,125 @@
+package repositorymongo
+
+import (
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-utils/umap"
+	"github.com/ace-zhaoy/go-utils/uslice"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Regex matches field against pattern, which is used as is. opts are regex options such as "i".
+func Regex(field, pattern string, opts string) bson.M {
+	regex := bson.M{"$regex": pattern}
+	if opts != "" {
+		regex["$options"] = opts
+	}
+	return bson.M{field: regex}
+}
+
+// StartsWith matches field values beginning with prefix. The prefix is escaped, so it is safe for user input.
+func StartsWith(field, prefix string, opts string) bson.M {
+	return Regex(field, "^"+regexp.QuoteMeta(prefix), opts)
+}
+
+// Contains matches field values containing substr. The substr is escaped, so it is safe for user input.
+func Contains(field, substr string, opts string) bson.M {
+	return Regex(field, regexp.QuoteMeta(substr), opts)
+}
+
+// Expr matches documents for which the aggregation expression expr is true, e.g. comparing two fields with
+// Expr(bson.M{"$gt": bson.A{"$spent", "$budget"}}). Only some `$expr` comparisons can use an index,
+// so prefer plain field filters where possible.
+func Expr(expr bson.M) bson.M {
+	return bson.M{"$expr": expr}
+}
+
+// ObjectIDFromHex is an id coercion for WithIDCoercion, turning ObjectID hex strings into ObjectIDs,
+// for string ids of documents whose `_id` is an ObjectID. Other values are returned as is.
+func ObjectIDFromHex(id any) any {
+	if s, ok := id.(string); ok {
+		if oid, err := primitive.ObjectIDFromHex(s); err == nil {
+			return oid
+		}
+	}
+	return id
+}
+
+// coerceIDValue applies coerce to an id filter value: an id, a list of ids, or an operator document on ids.
+func coerceIDValue(v any, coerce func(id any) any) any {
+	switch value := v.(type) {
+	case bson.M:
+		return bson.M(coerceIDOperators(value, coerce))
+	case map[string]any:
+		return coerceIDOperators(value, coerce)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		ids := make(bson.A, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			ids = append(ids, coerceIDValue(rv.Index(i).Interface(), coerce))
+		}
+		return ids
+	}
+	return coerce(v)
+}
+
+func coerceIDOperators(m map[string]any, coerce func(id any) any) map[string]any {
+	result := make(map[string]any, len(m))
+	for key, value := range m {
+		if !strings.HasPrefix(key, "$") {
+			// an embedded document id
+			return m
+		}
+		result[key] = coerceIDValue(value, coerce)
+	}
+	return result
+}
+
+// validateFilter rejects filter keys the server would fail on with an opaque error.
+func validateFilter(filter map[string]any) error {
+	for key := range filter {
+		if key == "" {
+			return ErrInvalidArgument.WrapStack(errors.NewWithMessage("empty filter key: %v", filter))
+		}
+	}
+	return nil
+}
+
+// normalizeFilterValue coerces int to int64 and float32 to float64, recursing into documents and arrays.
+func normalizeFilterValue(v any) any {
+	switch value := v.(type) {
+	case int:
+		return int64(value)
+	case float32:
+		return float64(value)
+	case []int:
+		return uslice.Map(value, func(item int) int64 { return int64(item) })
+	case []float32:
+		return uslice.Map(value, func(item float32) float64 { return float64(item) })
+	case bson.M:
+		return normalizeFilterMap(value)
+	case map[string]any:
+		return map[string]any(normalizeFilterMap(value))
+	case bson.D:
+		return bson.D(uslice.Map(value, func(item bson.E) bson.E {
+			return bson.E{Key: item.Key, Value: normalizeFilterValue(item.Value)}
+		}))
+	case bson.A:
+		return bson.A(uslice.Map(value, normalizeFilterValue))
+	case []any:
+		return uslice.Map(value, normalizeFilterValue)
+	}
+	return v
+}
+
+func normalizeFilterMap(m map[string]any) bson.M {
+	result := make(bson.M, len(m))
+	umap.Foreach(m, func(k string, v any) {
+		result[k] = normalizeFilterValue(v)
+	})
+	return result
+}
@@ -0,0 +1,168 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"testing"
+)
+
+func TestRegex(t *testing.T) {
+	assert.Equal(t, Regex("name", "^te.t$", ""), bson.M{"name": bson.M{"$regex": "^te.t$"}})
+	assert.Equal(t, Regex("name", "^te.t$", "i"), bson.M{"name": bson.M{"$regex": "^te.t$", "$options": "i"}})
+	assert.Equal(t, StartsWith("name", "a.b*", ""), bson.M{"name": bson.M{"$regex": `^a\.b\*`}})
+	assert.Equal(t, Contains("name", "(a+)+", ""), bson.M{"name": bson.M{"$regex": `\(a\+\)\+`}})
+	assert.Equal(t, Expr(bson.M{"$gt": bson.A{"$spent", "$budget"}}), bson.M{"$expr": bson.M{"$gt": bson.A{"$spent", "$budget"}}})
+}
+
+func TestCrudRepository_FindByFilter_Contains(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilter_Contains err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	for _, name := range []string{"a.test", "atest", "test"} {
+		_, err := userRepository.Create(context.Background(), &User{
+			ID:   idGen.Generate(),
+			Name: name,
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	collection, err := userRepository.FindByFilter(context.Background(), Contains("name", ".t", ""))
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.Count(), 1)
+	assert.Equal(t, collection.All()[0].Name, "a.test")
+}
+
+func TestCrudRepository_FindByFilter_StartsWith(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilter_StartsWith err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	for _, name := range []string{"Test1", "test2", "atest"} {
+		_, err := userRepository.Create(context.Background(), &User{
+			ID:   idGen.Generate(),
+			Name: name,
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	cnt, err := userRepository.CountByFilter(context.Background(), StartsWith("name", "test", ""))
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+
+	cnt, err = userRepository.CountByFilter(context.Background(), StartsWith("name", "test", "i"))
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+}
+
+type Project struct {
+	ID        int64 `json:"id" bson:"_id"`
+	Spent     int64 `json:"spent" bson:"spent"`
+	Budget    int64 `json:"budget" bson:"budget"`
+	DeletedAt int64 `json:"deleted_at" bson:"deleted_at"`
+}
+
+func (p *Project) GetID() int64 {
+	return p.ID
+}
+
+func (p *Project) SetID(id int64) {
+	p.ID = id
+}
+
+func TestCrudRepository_FindByFilter_Expr(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindByFilter_Expr err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	projectRepository := NewCrudRepository[int64, *Project](db.Collection("project"))
+	projects := []*Project{
+		{ID: idGen.Generate(), Spent: 120, Budget: 100},
+		{ID: idGen.Generate(), Spent: 80, Budget: 100},
+		{ID: idGen.Generate(), Spent: 150, Budget: 100},
+	}
+	for _, project := range projects {
+		_, err := projectRepository.Create(context.Background(), project)
+		errors.Check(errors.Wrap(err, "failed to create project"))
+	}
+	err := projectRepository.DeleteByID(context.Background(), projects[2].ID)
+	errors.Check(errors.Wrap(err, "failed to delete project"))
+
+	collection, err := projectRepository.FindByFilter(context.Background(), Expr(bson.M{"$gt": bson.A{"$spent", "$budget"}}))
+	errors.Check(errors.Wrap(err, "failed to find project"))
+	assert.Equal(t, collection.IDs(), []int64{projects[0].ID})
+}
+
+func TestCrudRepository_InvalidFilterKey(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_InvalidFilterKey err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	filter := map[string]any{"": "test"}
+
+	_, err := userRepository.FindByFilter(context.Background(), filter)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	_, err = userRepository.CountByFilter(context.Background(), filter)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.Update(context.Background(), filter, map[string]any{"name": "test2"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.Delete(context.Background(), filter)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.Unscoped().Delete(context.Background(), filter)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestNormalizeFilterValue(t *testing.T) {
+	assert.Equal(t, normalizeFilterValue(1), int64(1))
+	assert.Equal(t, normalizeFilterValue(float32(1.5)), float64(1.5))
+	assert.Equal(t, normalizeFilterValue(int32(1)), int32(1))
+	assert.Equal(t, normalizeFilterValue(bson.M{"$gt": 1, "$in": []int{1, 2}}), bson.M{"$gt": int64(1), "$in": []int64{1, 2}})
+	assert.Equal(t, normalizeFilterValue(bson.D{{Key: "$lt", Value: 1}}), bson.D{{Key: "$lt", Value: int64(1)}})
+	assert.Equal(t, normalizeFilterValue(bson.A{1, "a", bson.M{"b": 2}}), bson.A{int64(1), "a", bson.M{"b": int64(2)}})
+}
+
+type UserAge struct {
+	ID   int64  `json:"id" bson:"_id"`
+	Age  int64  `json:"age" bson:"age"`
+	Name string `json:"name" bson:"name"`
+}
+
+func (u *UserAge) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserAge) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_FilterNormalization(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FilterNormalization err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserAge](db.Collection("user"), WithFilterNormalization(true))
+	_, err := userRepository.Create(context.Background(), &UserAge{
+		ID:  idGen.Generate(),
+		Age: 30,
+	})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	cnt, err := userRepository.CountByFilter(context.Background(), map[string]any{"age": 30})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+
+	cnt, err = userRepository.CountByFilter(context.Background(), map[string]any{"age": bson.M{"$in": []int{20, 30}}})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+}
+
+func TestCoerceIDValue(t *testing.T) {
+	oid := primitive.NewObjectID()
+	assert.Equal(t, coerceIDValue(oid.Hex(), ObjectIDFromHex), any(oid))
+	assert.Equal(t, coerceIDValue("test", ObjectIDFromHex), any("test"))
+	assert.Equal(t, coerceIDValue(bson.M{"$in": []string{oid.Hex(), "test"}}, ObjectIDFromHex), any(bson.M{"$in": bson.A{oid, "test"}}))
+	assert.Equal(t, coerceIDValue(bson.M{"$gt": oid.Hex()}, ObjectIDFromHex), any(bson.M{"$gt": oid}))
+	assert.Equal(t, coerceIDValue(bson.M{"a": oid.Hex()}, ObjectIDFromHex), any(bson.M{"a": oid.Hex()}))
+}
@@ -0,0 +1,89 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+func (c *CrudRepository[ID, ENTITY]) ListIndexes(ctx context.Context) (indexes []bson.M, err error) {
+	defer errors.Recover(func(e error) { err = e })
+	cursor, err := c.collection.Indexes().List(ctx)
+	errors.Check(mapError(err))
+	err = cursor.All(ctx, &indexes)
+	errors.Check(mapError(err))
+	return
+}
+
+func (c *CrudRepository[ID, ENTITY]) DropIndex(ctx context.Context, name string) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", name) })
+	_, err = c.collection.Indexes().DropOne(ctx, name)
+	errors.Check(mapError(err))
+	return
+}
+
+// EnsureUniqueIndex creates a unique index on keys if it does not exist yet, and returns its name.
+// With a collation, uniqueness follows its comparison rules, e.g. &options.Collation{Locale: "en", Strength: 2}
+// makes "User@x" collide with "user@x". A nil collation compares values exactly.
+// Inserts violating the index fail with ErrDuplicatedKey.
+func (c *CrudRepository[ID, ENTITY]) EnsureUniqueIndex(ctx context.Context, keys bson.D, collation *options.Collation) (name string, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v", keys, collation) })
+	if len(keys) == 0 {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.New("no index keys")))
+	}
+	opts := options.Index().SetUnique(true)
+	if collation != nil {
+		opts.SetCollation(collation)
+	}
+	name, err = c.collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	errors.Check(mapError(err))
+	return
+}
+
+// EnableSoftDeleteTTL creates a TTL index making the server delete documents after they have been soft-deleted
+// for the duration after. TTL indexes only expire BSON dates, so the DeletedAt field must be a time.Time
+// or primitive.DateTime. The index is partial, covering only dates after the active value, so that the zero
+// date of active documents with a non-pointer field does not expire them.
+// It fails with ErrInvalidArgument for unix timestamps; use PurgeDeletedBefore for those instead.
+func (c *CrudRepository[ID, ENTITY]) EnableSoftDeleteTTL(ctx context.Context, after time.Duration) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", after) })
+	if !c.softDeleteEnabled || !isDateType(c.softDeleteType) {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("soft delete field %q is not a date", c.softDeleteField)))
+	}
+	var active any = time.Time{}
+	switch value := c.config.softDeleteActiveValue.(type) {
+	case time.Time, primitive.DateTime:
+		active = value
+	}
+	_, err = c.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: c.softDeleteField, Value: 1}},
+		Options: options.Index().
+			SetExpireAfterSeconds(int32(after / time.Second)).
+			SetPartialFilterExpression(bson.M{c.softDeleteField: bson.M{"$gt": active}}),
+	})
+	errors.Check(mapError(err))
+	return
+}
+
+// EnsureCollection creates the collection with the validator, e.g. bson.M{"$jsonSchema": schema}, if it does not
+// exist yet. An existing collection is left as is. Writes rejected by the validator fail with ErrValidation.
+func (c *CrudRepository[ID, ENTITY]) EnsureCollection(ctx context.Context, validator bson.M) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", validator) })
+	db := c.collection.Database()
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": c.collection.Name()})
+	errors.Check(mapError(err))
+	if len(names) > 0 {
+		return
+	}
+	err = db.CreateCollection(ctx, c.collection.Name(), options.CreateCollection().SetValidator(validator))
+	if hasErrorCode(err, codeNamespaceExists) {
+		// created concurrently
+		return nil
+	}
+	errors.Check(mapError(err))
+	return
+}
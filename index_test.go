@@ -0,0 +1,159 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"testing"
+	"time"
+)
+
+func indexNames(indexes []bson.M) []string {
+	names := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		names = append(names, index["name"].(string))
+	}
+	return names
+}
+
+func TestCrudRepository_ListIndexes_DropIndex(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ListIndexes_DropIndex err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	_, err := db.Collection("user").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetName("name_1"),
+	})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+
+	indexes, err := userRepository.ListIndexes(context.Background())
+	errors.Check(errors.Wrap(err, "failed to list indexes"))
+	assert.Equal(t, indexNames(indexes), []string{"_id_", "name_1"})
+
+	err = userRepository.DropIndex(context.Background(), "name_1")
+	errors.Check(errors.Wrap(err, "failed to drop index"))
+
+	indexes, err = userRepository.ListIndexes(context.Background())
+	errors.Check(errors.Wrap(err, "failed to list indexes"))
+	assert.Equal(t, indexNames(indexes), []string{"_id_"})
+}
+
+func TestCrudRepository_EnsureUniqueIndex(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_EnsureUniqueIndex err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	name, err := userRepository.EnsureUniqueIndex(context.Background(), bson.D{{Key: "name", Value: 1}}, &options.Collation{Locale: "en", Strength: 2})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+	assert.Equal(t, name, "name_1")
+	_, err = userRepository.EnsureUniqueIndex(context.Background(), bson.D{{Key: "name", Value: 1}}, &options.Collation{Locale: "en", Strength: 2})
+	errors.Check(errors.Wrap(err, "failed to ensure existing index"))
+
+	_, err = userRepository.Create(context.Background(), &User{ID: idGen.Generate(), Name: "User@x"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	_, err = userRepository.Create(context.Background(), &User{ID: idGen.Generate(), Name: "user@x"})
+	assert.Equal(t, errors.Is(err, repository.ErrDuplicatedKey), true)
+
+	_, err = userRepository.EnsureUniqueIndex(context.Background(), nil, nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+type UserDeletedDate struct {
+	ID        int64      `json:"id" bson:"_id"`
+	Name      string     `json:"name" bson:"name"`
+	DeletedAt *time.Time `json:"deleted_at" bson:"deleted_at,omitempty"`
+}
+
+func (u *UserDeletedDate) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserDeletedDate) SetID(id int64) {
+	u.ID = id
+}
+
+func TestCrudRepository_EnableSoftDeleteTTL(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_EnableSoftDeleteTTL err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+
+	err := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user")).EnableSoftDeleteTTL(context.Background(), time.Hour)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+
+	userRepository := NewCrudRepository[int64, *UserDeletedDate](db.Collection("user"))
+	err = userRepository.EnableSoftDeleteTTL(context.Background(), time.Hour)
+	errors.Check(errors.Wrap(err, "failed to enable soft delete ttl"))
+
+	indexes, err := userRepository.ListIndexes(context.Background())
+	errors.Check(errors.Wrap(err, "failed to list indexes"))
+	assert.Equal(t, indexNames(indexes), []string{"_id_", "deleted_at_1"})
+	assert.Equal(t, indexes[1]["expireAfterSeconds"], int32(3600))
+	assert.Equal(t, indexes[1]["partialFilterExpression"] != nil, true)
+}
+
+func TestCrudRepository_EnableSoftDeleteTTL_Time(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_EnableSoftDeleteTTL_Time err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserDeletedTime](db.Collection("user"))
+	err := userRepository.EnableSoftDeleteTTL(context.Background(), 0)
+	errors.Check(errors.Wrap(err, "failed to enable soft delete ttl"))
+	users := []*UserDeletedTime{
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+	}
+	for _, user := range users {
+		_, err = userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	err = userRepository.DeleteByID(context.Background(), users[1].ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	// the TTL monitor runs every 60 seconds
+	unscoped := NewCrudRepository[int64, *UserDeletedTime](db.Collection("user"), WithDefaultUnscoped(true))
+	for deadline := time.Now().Add(2 * time.Minute); time.Now().Before(deadline); time.Sleep(time.Second) {
+		exists, err := unscoped.ExistsByID(context.Background(), users[1].ID)
+		errors.Check(errors.Wrap(err, "failed to check user"))
+		if !exists {
+			break
+		}
+	}
+	exists, err := unscoped.ExistsByID(context.Background(), users[1].ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, false)
+	exists, err = userRepository.ExistsByID(context.Background(), users[0].ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, true)
+}
+
+func TestCrudRepository_EnsureCollection(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_EnsureCollection err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	validator := bson.M{"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"required": bson.A{"name"},
+		"properties": bson.M{
+			"name": bson.M{"bsonType": "string", "minLength": 1},
+		},
+	}}
+
+	err := userRepository.EnsureCollection(context.Background(), validator)
+	errors.Check(errors.Wrap(err, "failed to ensure collection"))
+	err = userRepository.EnsureCollection(context.Background(), validator)
+	errors.Check(errors.Wrap(err, "failed to ensure existing collection"))
+
+	_, err = userRepository.Create(context.Background(), &User{ID: idGen.Generate(), Name: "test"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	_, err = userRepository.Create(context.Background(), &User{ID: idGen.Generate()})
+	assert.Equal(t, errors.Is(err, ErrValidation), true)
+}
@@ -0,0 +1,71 @@
+package repositorymongo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"io"
+)
+
+// maxJSONLLine bounds the lines read by ImportJSONL, leaving room for the extended JSON of a 16MB document.
+const maxJSONLLine = 64 << 20
+
+// ExportJSONL writes the matched documents to w as JSON lines, one canonical extended JSON document per line
+// so that BSON types survive a round trip, and returns how many it wrote. Documents are streamed from the cursor
+// rather than loaded at once, and exported whole: WithArraySlice, WithDefaultProjection and WithHint do not apply.
+func (c *CrudRepository[ID, ENTITY]) ExportJSONL(ctx context.Context, filter map[string]any, w io.Writer) (count int, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	cursor, err := c.collection.Find(ctx, c.buildFilter(filter), c.newFindOptions())
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		errors.Check(errors.WithStack(err))
+		_, err = w.Write(append(line, '\n'))
+		errors.Check(errors.WithStack(err))
+		count++
+	}
+	errors.Check(mapError(cursor.Err()))
+	return
+}
+
+// ImportJSONL reads extended JSON lines from r, such as those of ExportJSONL, decodes each into an ENTITY
+// and inserts them with BatchCreate, batchSize at a time. Blank lines are skipped. It returns how many were
+// inserted; on an error, including ErrDuplicatedKey for documents that already exist, it stops after the
+// failing batch, whose other documents are still inserted.
+func (c *CrudRepository[ID, ENTITY]) ImportJSONL(ctx context.Context, r io.Reader, batchSize int) (count int, err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", batchSize) })
+	if batchSize < 1 {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid batch size: %d", batchSize)))
+	}
+	insert := func(batch []ENTITY) {
+		ids, err := c.BatchCreate(ctx, batch)
+		count += len(ids)
+		errors.Check(err)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxJSONLLine)
+	batch := make([]ENTITY, 0, batchSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entity ENTITY
+		err = bson.UnmarshalExtJSONWithRegistry(c.registry, scanner.Bytes(), false, &entity)
+		errors.Check(errors.Wrap(err, "line %d", line))
+		batch = append(batch, entity)
+		if len(batch) == batchSize {
+			insert(batch)
+			batch = make([]ENTITY, 0, batchSize)
+		}
+	}
+	errors.Check(errors.WithStack(scanner.Err()))
+	if len(batch) > 0 {
+		insert(batch)
+	}
+	return
+}
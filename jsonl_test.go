@@ -0,0 +1,86 @@
+package repositorymongo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestCrudRepository_ExportJSONL(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ExportJSONL err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	users := []*UserSoftDelete{
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+		{ID: idGen.Generate(), Name: "test"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+	err := userRepository.DeleteByID(context.Background(), users[2].ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	var buf bytes.Buffer
+	exporter := userRepository.With(WithDefaultProjection(bson.D{{Key: "name", Value: 0}}))
+	count, err := exporter.ExportJSONL(context.Background(), map[string]any{"name": "test"}, &buf)
+	errors.Check(errors.Wrap(err, "failed to export users"))
+	assert.Equal(t, count, 2)
+
+	var exported []UserSoftDelete
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var user UserSoftDelete
+		errors.Check(bson.UnmarshalExtJSON(scanner.Bytes(), true, &user))
+		exported = append(exported, user)
+	}
+	errors.Check(scanner.Err())
+	assert.Equal(t, exported, []UserSoftDelete{*users[0], *users[1]})
+}
+
+func TestCrudRepository_ImportJSONL(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_ImportJSONL err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	backupRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user_backup"))
+
+	users := make([]*UserSoftDelete, 0, 5)
+	for i := 0; i < 5; i++ {
+		user := &UserSoftDelete{ID: idGen.Generate(), Name: "test"}
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		users = append(users, user)
+	}
+	var buf bytes.Buffer
+	_, err := userRepository.ExportJSONL(context.Background(), nil, &buf)
+	errors.Check(errors.Wrap(err, "failed to export users"))
+	exported := buf.String()
+
+	count, err := backupRepository.ImportJSONL(context.Background(), strings.NewReader(exported+"\n"), 2)
+	errors.Check(errors.Wrap(err, "failed to import users"))
+	assert.Equal(t, count, 5)
+	collection, err := backupRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.All(), users)
+
+	// importing again collides on every id
+	count, err = backupRepository.ImportJSONL(context.Background(), strings.NewReader(exported), 2)
+	assert.Equal(t, errors.Is(err, repository.ErrDuplicatedKey), true)
+	assert.Equal(t, count, 0)
+
+	_, err = backupRepository.ImportJSONL(context.Background(), strings.NewReader("{"), 2)
+	assert.Equal(t, err != nil, true)
+	_, err = backupRepository.ImportJSONL(context.Background(), strings.NewReader(exported), 0)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
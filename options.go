@@ -0,0 +1,302 @@
+package repositorymongo
+
+import (
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"reflect"
+	"time"
+)
+
+type config struct {
+	orderedSoftDelete        bool
+	softDeleteActiveValue    any
+	softDeleteActiveValueSet bool
+	softDeleteUpdater        func() bson.M
+	softDeleteActiveFilter   func() bson.M
+	softDeleteFlagField      string
+	batchSize                int
+	batchConcurrency         int
+	normalizeFilter          bool
+	requireID                bool
+	hint                     any
+	emptyFilterGuard         bool
+	coerceID                 func(id any) any
+	transactionRetries       int
+	discriminatorField       string
+	discriminatorTypes       map[string]func() any
+	maxResults               int
+	arraySlices              bson.D
+	defaultUnscoped          bool
+	readTimeout              time.Duration
+	writeTimeout             time.Duration
+	idField                  string
+	registry                 *bsoncodec.Registry
+	existsByIDsProjection    bson.D
+	comment                  string
+	stripIDOnUpdate          bool
+	defaultProjection        bson.D
+	readConcern              *readconcern.ReadConcern
+}
+
+type Option func(c *config)
+
+// WithOrderedSoftDelete soft-deletes matched documents one by one in id order, stamping each
+// with a distinct, strictly increasing timestamp instead of a single UpdateMany.
+// It trades write performance for ordering fidelity. The stamps step forward from the deletion time by a millisecond
+// for a date field and by a second for unix seconds, within a minute: a delete matching more documents than that
+// allows, e.g. more than 60 with unix seconds, fails with ErrInvalidArgument before any is deleted.
+func WithOrderedSoftDelete(enabled bool) Option {
+	return func(c *config) {
+		c.orderedSoftDelete = enabled
+	}
+}
+
+// WithSoftDeleteActiveValue sets the soft-delete field value that marks a document as not deleted. The default is 0,
+// or false for a bool field and the zero time for a date field, nil if it is a pointer.
+// Create fills a zero soft-delete field with it, so schemas using e.g. -1 stay consistent.
+func WithSoftDeleteActiveValue(value any) Option {
+	return func(c *config) {
+		c.softDeleteActiveValue = value
+		c.softDeleteActiveValueSet = true
+	}
+}
+
+// WithSoftDeleteUpdater replaces the `$set` document written by soft delete, e.g. to mark several fields.
+// It is usually paired with WithSoftDeleteActiveFilter. Soft delete still requires a DeletedAt field.
+func WithSoftDeleteUpdater(updater func() bson.M) Option {
+	return func(c *config) {
+		c.softDeleteUpdater = updater
+	}
+}
+
+// WithSoftDeleteActiveFilter replaces the predicate matching documents that are not soft-deleted.
+// It is combined with query filters under `$and`, so it may span several fields with its own `$or`,
+// e.g. to treat a document as deleted when either deleted_at is set or status is "archived".
+func WithSoftDeleteActiveFilter(filter func() bson.M) Option {
+	return func(c *config) {
+		c.softDeleteActiveFilter = filter
+	}
+}
+
+// WithSoftDeleteFlag makes soft delete also set the boolean field to true, and matches active documents
+// on it being false or missing instead of on the timestamp, as a boolean is cheaper to index.
+// WithSoftDeleteActiveFilter takes precedence over it.
+func WithSoftDeleteFlag(field string) Option {
+	return func(c *config) {
+		c.softDeleteFlagField = field
+	}
+}
+
+// WithDefaultUnscoped makes the repository include soft-deleted documents, as if Unscoped had been called,
+// e.g. for admin tools. Like Unscoped, its deletes are hard deletes. Scoped restores the soft delete behavior.
+// It only takes effect in NewCrudRepository: With panics with ErrInvalidArgument if it changes it.
+func WithDefaultUnscoped(enabled bool) Option {
+	return func(c *config) {
+		c.defaultUnscoped = enabled
+	}
+}
+
+// WithIDField sets the document field the id methods, such as FindByIDs and DeleteByIDs, query with `$in`,
+// for entities whose GetID returns a logical id stored under another field than the `ID` struct field,
+// e.g. a "code" while `_id` holds an ObjectID. By default it is the field of `ID`, or `Id`.
+// As only `_id` is generated, the create methods reject entities with a zero id when it is another field.
+// It only takes effect in NewCrudRepository: With panics with ErrInvalidArgument if it changes it.
+func WithIDField(field string) Option {
+	return func(c *config) {
+		c.idField = field
+	}
+}
+
+// WithRegistry makes the repository encode entities and filters, and decode documents, with registry,
+// e.g. one with codecs for enum types, instead of the registry of the collection. WithDiscriminator, which
+// installs its own registry, takes precedence.
+// It only takes effect in NewCrudRepository: With panics with ErrInvalidArgument if it changes it.
+func WithRegistry(registry *bsoncodec.Registry) Option {
+	return func(c *config) {
+		c.registry = registry
+	}
+}
+
+// WithReadConcern makes FindOne, FindByID, FindByIDWithDeleted and FindRawByID read with readConcern, e.g.
+// readconcern.Linearizable() for a critical read that must observe every write acknowledged by a majority before it.
+// A linearizable read is only served by the primary, so the client must read from the primary, and it waits for a
+// majority of the replica set to confirm the primary, adding a round trip; pair it with WithReadTimeout.
+func WithReadConcern(readConcern *readconcern.ReadConcern) Option {
+	return func(c *config) {
+		c.readConcern = readConcern
+	}
+}
+
+// WithReadTimeout sets the deadline of the find, count, exists and aggregation methods
+// when the caller's context has none. The default is 0, meaning no deadline.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout is like WithReadTimeout, for the create, update, upsert and delete methods.
+// Index, stream, watch, export and transaction methods use neither timeout.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.writeTimeout = timeout
+	}
+}
+
+// WithBatchSize sets how many ids are sent in one `$in` query by the id list methods. The default is 1000.
+func WithBatchSize(size int) Option {
+	return func(c *config) {
+		c.batchSize = size
+	}
+}
+
+// WithBatchConcurrency sets how many id batches are queried at the same time. The default is 1.
+func WithBatchConcurrency(concurrency int) Option {
+	return func(c *config) {
+		c.batchConcurrency = concurrency
+	}
+}
+
+// WithFilterNormalization makes filters coerce Go int values to int64 and float32 values to float64,
+// including values nested in operators, so they match fields stored with the wider BSON types.
+func WithFilterNormalization(enabled bool) Option {
+	return func(c *config) {
+		c.normalizeFilter = enabled
+	}
+}
+
+// WithRequireID makes Create fail with ErrInvalidArgument when the entity id is zero,
+// instead of inserting a document whose id is the zero value.
+func WithRequireID(enabled bool) Option {
+	return func(c *config) {
+		c.requireID = enabled
+	}
+}
+
+// WithHint makes the find and count methods use the index, given by name or by key specification as a bson.D.
+func WithHint(hint any) Option {
+	return func(c *config) {
+		c.hint = hint
+	}
+}
+
+// WithComment attaches comment to the find, count, aggregate, update, delete and bulk write commands, so that
+// they can be attributed in the slow query log and currentOp, e.g. repo.With(WithComment("billing: monthly report")).
+func WithComment(comment string) Option {
+	return func(c *config) {
+		c.comment = comment
+	}
+}
+
+// WithStripIDOnUpdate makes the update methods taking a data map, such as UpdateByID and PatchByID, drop `_id`,
+// which cannot be changed, from the data, e.g. when echoing a whole document back, instead of failing with
+// ErrInvalidArgument. The update methods taking an entity, such as UpdateNonZeroByID, always leave `_id` out.
+func WithStripIDOnUpdate(enabled bool) Option {
+	return func(c *config) {
+		c.stripIDOnUpdate = enabled
+	}
+}
+
+// WithEmptyFilterGuard makes Update, UpdateNonZero, Delete, DeleteOne and DeleteAllByFilter fail with
+// ErrInvalidArgument on an empty filter, so matching every document takes an explicit DeleteAll or UpdateAll.
+func WithEmptyFilterGuard(enabled bool) Option {
+	return func(c *config) {
+		c.emptyFilterGuard = enabled
+	}
+}
+
+// WithIDCoercion converts the ids in filters on the id field with coerce, e.g. ObjectIDFromHex,
+// for ids whose Go type differs from the stored BSON type. Numeric ids need no coercion,
+// as the server compares int32, int64 and double values by value.
+func WithIDCoercion(coerce func(id any) any) Option {
+	return func(c *config) {
+		c.coerceID = coerce
+	}
+}
+
+// WithTransactionRetries makes Transaction retry up to retries times on transient transaction errors,
+// such as write conflicts under contention. The default is 0.
+func WithTransactionRetries(retries int) Option {
+	return func(c *config) {
+		c.transactionRetries = retries
+	}
+}
+
+// WithDiscriminator lets ENTITY be an interface implemented by several types stored in one collection.
+// Each document is decoded into a value made by the factory registered in types for its field value,
+// e.g. types["circle"] = func() any { return &Circle{} }. The types must share the id and soft delete fields,
+// and at least one must be registered, or NewCrudRepository panics with ErrInvalidArgument.
+// It only takes effect in NewCrudRepository: With panics with ErrInvalidArgument if it changes it.
+func WithDiscriminator(field string, types map[string]func() any) Option {
+	return func(c *config) {
+		c.discriminatorField = field
+		c.discriminatorTypes = types
+	}
+}
+
+// WithMaxResults makes FindAll and the FindByFilter methods without a limit fail with ErrTooManyResults
+// when more than max documents match, instead of reading them all into memory. At most max+1 documents are read.
+// The default is 0, meaning no limit.
+func WithMaxResults(max int) Option {
+	return func(c *config) {
+		c.maxResults = max
+	}
+}
+
+// WithArraySlice makes the find methods return only the first n elements of the array field, or the last -n
+// if n is negative, e.g. the latest comments of a post. It may be given once per field.
+func WithArraySlice(field string, n int) Option {
+	return func(c *config) {
+		// copied, as the slices of a repository and its clones must not share a backing array
+		c.arraySlices = append(append(bson.D{}, c.arraySlices...), bson.E{Key: field, Value: n})
+	}
+}
+
+// WithExistsByIDsProjection makes ExistsByIDs project projection instead of the id field. It must include
+// the id field, e.g. with 1 or true, and may exclude `_id`, e.g. bson.D{{"_id", 0}, {"code", 1}} with WithIDField("code"),
+// so that a unique index on the id field covers the query.
+func WithExistsByIDsProjection(projection bson.D) Option {
+	return func(c *config) {
+		c.existsByIDsProjection = projection
+	}
+}
+
+// WithDefaultProjection applies projection to the find methods, e.g. bson.D{{"avatar", 0}} to leave out a large
+// field, along with WithArraySlice. A projection passed to FindByFilterWithOptions overrides it.
+func WithDefaultProjection(projection bson.D) Option {
+	return func(c *config) {
+		c.defaultProjection = projection
+	}
+}
+
+// sameConstruction reports whether c and other agree on the options that only take effect in NewCrudRepository.
+func (c config) sameConstruction(other config) bool {
+	return c.idField == other.idField &&
+		c.registry == other.registry &&
+		c.discriminatorField == other.discriminatorField &&
+		reflect.ValueOf(c.discriminatorTypes).Pointer() == reflect.ValueOf(other.discriminatorTypes).Pointer() &&
+		c.defaultUnscoped == other.defaultUnscoped
+}
+
+func validateHint(hint any) error {
+	switch h := hint.(type) {
+	case string:
+		if h != "" {
+			return nil
+		}
+	case bson.D:
+		if len(h) > 0 {
+			return nil
+		}
+	}
+	return ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid hint: %#v", hint))
+}
+
+func validateArraySlice(slice bson.E) error {
+	if slice.Key == "" || slice.Value == 0 {
+		return ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid array slice: %q, %v", slice.Key, slice.Value))
+	}
+	return nil
+}
@@ -0,0 +1,539 @@
+package repositorymongo
+
+import (
+	"bytes"
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// commandRecorder records the commands sent to the server by name.
+type commandRecorder struct {
+	mu       sync.Mutex
+	commands map[string][]bson.Raw
+}
+
+func newCommandRecorder() *commandRecorder {
+	return &commandRecorder{commands: map[string][]bson.Raw{}}
+}
+
+func (r *commandRecorder) started(evt *event.CommandStartedEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands[evt.CommandName] = append(r.commands[evt.CommandName], evt.Command)
+}
+
+func (r *commandRecorder) last(name string) bson.Raw {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	commands := r.commands[name]
+	if len(commands) == 0 {
+		return nil
+	}
+	return commands[len(commands)-1]
+}
+
+func (r *commandRecorder) count(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.commands[name])
+}
+
+func TestCrudRepository_WithHint(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithHint err: %+v", e) })
+	recorder := newCommandRecorder()
+	db, teardown := getMonitoredDatabase(recorder.started)
+	defer teardown()
+	_, err := db.Collection("user").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetName("name_1"),
+	})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	_, err = userRepository.Create(context.Background(), &User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	collection, err := userRepository.With(WithHint("name_1")).FindByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, collection.Count(), 1)
+	assert.Equal(t, recorder.last("find").Lookup("hint").StringValue(), "name_1")
+
+	cnt, err := userRepository.With(WithHint(bson.D{{Key: "name", Value: 1}})).CountByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 1)
+	assert.Equal(t, recorder.last("aggregate").Lookup("hint").Document().String(), `{"name": {"$numberInt":"1"}}`)
+
+	_, err = userRepository.FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	_, err = recorder.last("find").LookupErr("hint")
+	assert.Equal(t, err != nil, true)
+}
+
+func TestCrudRepository_WithHint_Count(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithHint_Count err: %+v", e) })
+	recorder := newCommandRecorder()
+	db, teardown := getMonitoredDatabase(recorder.started)
+	defer teardown()
+	_, err := db.Collection("user").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetName("name_1"),
+	})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	for _, name := range []string{"test", "test", "test2"} {
+		_, err = userRepository.Create(context.Background(), &UserSoftDelete{ID: idGen.Generate(), Name: name})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	hinted := userRepository.With(WithHint("name_1"))
+	cnt, err := hinted.Count(context.Background())
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 3)
+	assert.Equal(t, recorder.last("aggregate").Lookup("hint").StringValue(), "name_1")
+
+	cnt, err = hinted.CountByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, cnt, 2)
+	assert.Equal(t, recorder.last("aggregate").Lookup("hint").StringValue(), "name_1")
+
+	_, err = userRepository.With(WithHint(bson.D{})).CountByFilter(context.Background(), map[string]any{"name": "test"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_WithHint_Invalid(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithHint_Invalid err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	_, err := userRepository.With(WithHint(1)).FindAll(context.Background())
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	_, err = userRepository.With(WithHint("")).Count(context.Background())
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	// concurrent batches report it too
+	ids := []int64{idGen.Generate(), idGen.Generate(), idGen.Generate()}
+	_, err = userRepository.With(WithHint(1), WithBatchSize(1), WithBatchConcurrency(2)).FindByIDs(context.Background(), ids)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_WithMaxResults(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithMaxResults err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"), WithMaxResults(3))
+	for i := 0; i < 3; i++ {
+		_, err := userRepository.Create(context.Background(), &User{ID: idGen.Generate(), Name: "test"})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	collection, err := userRepository.FindByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.Count(), 3)
+
+	_, err = userRepository.Create(context.Background(), &User{ID: idGen.Generate(), Name: "test"})
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	_, err = userRepository.FindByFilter(context.Background(), map[string]any{"name": "test"})
+	assert.Equal(t, errors.Is(err, ErrTooManyResults), true)
+	_, err = userRepository.FindAll(context.Background())
+	assert.Equal(t, errors.Is(err, ErrTooManyResults), true)
+	_, err = userRepository.FindByFilterDict(context.Background(), map[string]any{"name": "test"})
+	assert.Equal(t, errors.Is(err, ErrTooManyResults), true)
+
+	collection, err = userRepository.FindByFilterWithOptions(context.Background(), map[string]any{"name": "test"}, options.Find().SetLimit(10))
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.Count(), 4)
+	collection, err = userRepository.FindByFilterWithPage(context.Background(), map[string]any{"name": "test"}, 10, 0)
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.Count(), 4)
+	collection, err = userRepository.With(WithMaxResults(0)).FindAll(context.Background())
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.Count(), 4)
+}
+
+func TestCrudRepository_WithArraySlice(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithArraySlice err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserTags](db.Collection("user"))
+	tags := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	user := UserTags{ID: idGen.Generate(), Tags: tags}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	sliced := userRepository.With(WithArraySlice("tags", 3))
+	user2, err := sliced.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.ID, user.ID)
+	assert.Equal(t, user2.Tags, []string{"a", "b", "c"})
+
+	collection, err := userRepository.With(WithArraySlice("tags", -2)).FindByFilter(context.Background(), map[string]any{})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.All()[0].Tags, []string{"i", "j"})
+
+	user3, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user3.Tags, tags)
+}
+
+func TestCrudRepository_WithArraySlice_Invalid(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithArraySlice_Invalid err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserTags](db.Collection("user"))
+
+	_, err := userRepository.With(WithArraySlice("tags", 0)).FindByID(context.Background(), idGen.Generate())
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	_, err = userRepository.With(WithArraySlice("", 3)).FindAll(context.Background())
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_WithReadTimeout_WithWriteTimeout(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithReadTimeout_WithWriteTimeout err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"), WithReadTimeout(time.Nanosecond), WithWriteTimeout(time.Minute))
+
+	user := User{ID: idGen.Generate(), Name: "test"}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	err = userRepository.UpdateByID(context.Background(), user.ID, map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+
+	_, err = userRepository.FindByID(context.Background(), user.ID)
+	assert.Equal(t, mongo.IsTimeout(err), true)
+	_, err = userRepository.Count(context.Background())
+	assert.Equal(t, mongo.IsTimeout(err), true)
+
+	// the caller's deadline takes precedence
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	user2, err := userRepository.FindByID(ctx, user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test2")
+
+	userRepository = userRepository.With(WithReadTimeout(time.Minute), WithWriteTimeout(time.Nanosecond))
+	err = userRepository.DeleteByID(context.Background(), user.ID)
+	assert.Equal(t, mongo.IsTimeout(err), true)
+	err = userRepository.Update(context.Background(), map[string]any{"name": "test2"}, map[string]any{"name": "test3"})
+	assert.Equal(t, mongo.IsTimeout(err), true)
+	exists, err := userRepository.ExistsByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, true)
+}
+
+type Level int
+
+const (
+	LevelGuest Level = iota
+	LevelAdmin
+)
+
+var levelNames = map[Level]string{LevelGuest: "guest", LevelAdmin: "admin"}
+
+type UserLevel struct {
+	ID    int64  `json:"id" bson:"_id"`
+	Name  string `json:"name" bson:"name"`
+	Level Level  `json:"level" bson:"level"`
+}
+
+func (u *UserLevel) GetID() int64 {
+	return u.ID
+}
+
+func (u *UserLevel) SetID(id int64) {
+	u.ID = id
+}
+
+// levelRegistry stores Level values by name.
+func levelRegistry() *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	levelType := reflect.TypeOf(Level(0))
+	registry.RegisterTypeEncoder(levelType, bsoncodec.ValueEncoderFunc(
+		func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+			return vw.WriteString(levelNames[val.Interface().(Level)])
+		},
+	))
+	registry.RegisterTypeDecoder(levelType, bsoncodec.ValueDecoderFunc(
+		func(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+			name, err := vr.ReadString()
+			if err != nil {
+				return err
+			}
+			for level, levelName := range levelNames {
+				if levelName == name {
+					val.Set(reflect.ValueOf(level))
+					return nil
+				}
+			}
+			return errors.NewWithStack("unknown level: %s", name)
+		},
+	))
+	return registry
+}
+
+func TestCrudRepository_WithRegistry(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithRegistry err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserLevel](db.Collection("user"), WithRegistry(levelRegistry()))
+
+	user := UserLevel{ID: idGen.Generate(), Name: "test", Level: LevelAdmin}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	raw, err := db.Collection("user").FindOne(context.Background(), bson.M{"_id": user.ID}).Raw()
+	errors.Check(errors.Wrap(err, "failed to find raw user"))
+	assert.Equal(t, raw.Lookup("level").StringValue(), "admin")
+
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, *user2, user)
+
+	// filters are encoded with the registry too
+	count, err := userRepository.CountByFilter(context.Background(), map[string]any{"_id": user.ID, "level": LevelAdmin})
+	errors.Check(errors.Wrap(err, "failed to count users"))
+	assert.Equal(t, count, 1)
+
+	// and so are imported documents
+	var buf bytes.Buffer
+	_, err = userRepository.ExportJSONL(context.Background(), nil, &buf)
+	errors.Check(errors.Wrap(err, "failed to export users"))
+	backupRepository := NewCrudRepository[int64, *UserLevel](db.Collection("user_backup"), WithRegistry(levelRegistry()))
+	_, err = backupRepository.ImportJSONL(context.Background(), &buf, 10)
+	errors.Check(errors.Wrap(err, "failed to import users"))
+	user2, err = backupRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, *user2, user)
+}
+
+func TestCrudRepository_WithExistsByIDsProjection(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithExistsByIDsProjection err: %+v", e) })
+	recorder := newCommandRecorder()
+	db, teardown := getMonitoredDatabase(recorder.started)
+	defer teardown()
+	_, err := db.Collection("user").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	errors.Check(errors.Wrap(err, "failed to create index"))
+	projection := bson.D{{Key: "_id", Value: 0}, {Key: "code", Value: 1}}
+	userRepository := NewCrudRepository[string, *UserCode](db.Collection("user"), WithIDField("code"), WithExistsByIDsProjection(projection))
+	for _, code := range []string{"a", "b"} {
+		_, err = userRepository.Create(context.Background(), &UserCode{Code: code, Name: "test"})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	exists, err := userRepository.ExistsByIDs(context.Background(), []string{"a", "b", "c"})
+	errors.Check(errors.Wrap(err, "failed to check users"))
+	for code, want := range map[string]bool{"a": true, "b": true, "c": false} {
+		got, _ := exists.Get(code)
+		assert.Equal(t, got, want)
+	}
+	assert.Equal(t, recorder.last("find").Lookup("projection").Document().String(), `{"_id": {"$numberInt":"0"},"code": {"$numberInt":"1"}}`)
+
+	_, err = userRepository.With(WithExistsByIDsProjection(bson.D{{Key: "_id", Value: 1}})).ExistsByIDs(context.Background(), []string{"a"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	_, err = userRepository.With(WithExistsByIDsProjection(bson.D{{Key: "_id", Value: 1}, {Key: "code", Value: 0}})).ExistsByIDs(context.Background(), []string{"a"})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+
+	// with the default id field, `_id` must not be excluded
+	userRepository2 := NewCrudRepository[int64, *User](db.Collection("user"), WithExistsByIDsProjection(bson.D{{Key: "_id", Value: 0}, {Key: "name", Value: 1}}))
+	_, err = userRepository2.ExistsByIDs(context.Background(), []int64{idGen.Generate()})
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_WithComment(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithComment err: %+v", e) })
+	recorder := newCommandRecorder()
+	db, teardown := getMonitoredDatabase(recorder.started)
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	commented := userRepository.With(WithComment("report"))
+	user := UserSoftDelete{ID: idGen.Generate(), Name: "test"}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	_, err = commented.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, recorder.last("find").Lookup("comment").StringValue(), "report")
+	_, err = commented.CountByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to count user"))
+	assert.Equal(t, recorder.last("aggregate").Lookup("comment").StringValue(), "report")
+	err = commented.UpdateByID(context.Background(), user.ID, map[string]any{"name": "test2"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	assert.Equal(t, recorder.last("update").Lookup("comment").StringValue(), "report")
+	err = userRepository.UpdateByID(context.Background(), user.ID, map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	_, err = commented.UpdateEach(context.Background(), map[int64]map[string]any{user.ID: {"name": "test3"}})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	assert.Equal(t, recorder.last("update").Lookup("comment").StringValue(), "report")
+
+	_, err = userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	_, err = recorder.last("find").LookupErr("comment")
+	assert.Equal(t, err != nil, true)
+
+	err = commented.Unscoped().DeleteByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	assert.Equal(t, recorder.last("delete").Lookup("comment").StringValue(), "report")
+}
+
+func TestCrudRepository_WithStripIDOnUpdate(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithStripIDOnUpdate err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	user := User{ID: idGen.Generate(), Name: "test"}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	data := map[string]any{"_id": idGen.Generate(), "name": "test2"}
+
+	err = userRepository.UpdateByID(context.Background(), user.ID, data)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.Update(context.Background(), map[string]any{"name": "test"}, data)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	err = userRepository.Upsert(context.Background(), map[string]any{"name": "test"}, data, nil)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	_, err = userRepository.ClaimNext(context.Background(), map[string]any{"name": "test"}, data)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test")
+
+	err = userRepository.With(WithStripIDOnUpdate(true)).UpdateByID(context.Background(), user.ID, data)
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	user2, err = userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test2")
+	assert.Equal(t, len(data), 2)
+
+	// the `_id` of an entity is left out
+	err = userRepository.UpdateNonZeroByID(context.Background(), user.ID, &User{ID: idGen.Generate(), Name: "test3"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	err = userRepository.UpdateNonZero(context.Background(), map[string]any{"name": "test3"}, &User{ID: idGen.Generate(), Name: "test4"})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	user2, err = userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test4")
+
+	// an id field other than `_id` can be updated
+	user1Repository := NewCrudRepository[int64, *User1](db.Collection("user1"))
+	user1 := User1{ID: idGen.Generate()}
+	_, err = user1Repository.Create(context.Background(), &user1)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	newID := idGen.Generate()
+	err = user1Repository.UpdateByID(context.Background(), user1.ID, map[string]any{"mongo_id": newID})
+	errors.Check(errors.Wrap(err, "failed to update user"))
+	_, err = user1Repository.FindByID(context.Background(), newID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+}
+
+func TestCrudRepository_WithDefaultProjection(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithDefaultProjection err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserWithProfile](db.Collection("user"), WithDefaultProjection(bson.D{{Key: "profile", Value: 0}}))
+	user := UserWithProfile{ID: idGen.Generate(), Name: "test", UserProfile: &UserProfile{Bio: "bio"}}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	user2, err := userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test")
+	assert.Equal(t, user2.UserProfile == nil, true)
+	collection, err := userRepository.FindByFilter(context.Background(), map[string]any{"name": "test"})
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.Count(), 1)
+	assert.Equal(t, collection.All()[0].UserProfile == nil, true)
+
+	collection, err = userRepository.FindByFilterWithOptions(context.Background(), map[string]any{"name": "test"}, options.Find().SetProjection(bson.D{}))
+	errors.Check(errors.Wrap(err, "failed to find users"))
+	assert.Equal(t, collection.All()[0].UserProfile, &UserProfile{Bio: "bio"})
+	user2, err = userRepository.With(WithDefaultProjection(nil)).FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.UserProfile, &UserProfile{Bio: "bio"})
+}
+
+func TestCrudRepository_WithReadConcern(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WithReadConcern err: %+v", e) })
+	recorder := newCommandRecorder()
+	db, teardown := getMonitoredDatabase(recorder.started)
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	user := User{ID: idGen.Generate(), Name: "test"}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	linearizable := userRepository.With(WithReadConcern(readconcern.Linearizable()))
+	user2, err := linearizable.FindByID(context.Background(), user.ID)
+	assert.Equal(t, recorder.last("find").Lookup("readConcern", "level").StringValue(), "linearizable")
+	if errors.Is(err, ErrReplicaSetRequired) {
+		t.Skip("linearizable reads require a replica set")
+	}
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, user2.Name, "test")
+
+	_, err = userRepository.FindByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	_, err = recorder.last("find").LookupErr("readConcern")
+	assert.Equal(t, err != nil, true)
+
+	// so does FindByIDWithDeleted, in a single read for an active entity
+	softDeleteRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user_soft_delete"), WithReadConcern(readconcern.Linearizable()))
+	softDeleteUser := UserSoftDelete{ID: idGen.Generate(), Name: "test"}
+	_, err = softDeleteRepository.Create(context.Background(), &softDeleteUser)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+	finds := recorder.count("find")
+	_, deleted, err := softDeleteRepository.FindByIDWithDeleted(context.Background(), softDeleteUser.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, deleted, false)
+	assert.Equal(t, recorder.count("find"), finds+1)
+	assert.Equal(t, recorder.count("aggregate"), 0)
+	assert.Equal(t, recorder.last("find").Lookup("readConcern", "level").StringValue(), "linearizable")
+
+	err = softDeleteRepository.DeleteByID(context.Background(), softDeleteUser.ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	_, deleted, err = softDeleteRepository.FindByIDWithDeleted(context.Background(), softDeleteUser.ID)
+	errors.Check(errors.Wrap(err, "failed to find user"))
+	assert.Equal(t, deleted, true)
+	assert.Equal(t, recorder.last("find").Lookup("readConcern", "level").StringValue(), "linearizable")
+}
+
+func TestCrudRepository_With_ConstructionOnly(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_With_ConstructionOnly err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"), WithIDField("_id"))
+	with := func(opts ...Option) (err error) {
+		defer errors.Recover(func(e error) { err = e })
+		userRepository.With(opts...)
+		return
+	}
+
+	for _, opt := range []Option{
+		WithIDField("name"),
+		WithRegistry(levelRegistry()),
+		WithDiscriminator("kind", map[string]func() any{"user": func() any { return &UserSoftDelete{} }}),
+		WithDefaultUnscoped(true),
+	} {
+		assert.Equal(t, errors.Is(with(opt), ErrInvalidArgument), true)
+	}
+	// restating the construction options is fine
+	errors.Check(with(WithIDField("_id"), WithDefaultUnscoped(false), WithReadTimeout(time.Second)))
+}
@@ -0,0 +1,100 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository/contract"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PageResult is a page of entities with the pagination metadata of API responses.
+type PageResult[ID comparable, ENTITY contract.ENTITY[ID]] struct {
+	Items      []ENTITY `json:"items"`
+	Total      int      `json:"total"`
+	Page       int      `json:"page"`
+	Size       int      `json:"size"`
+	TotalPages int      `json:"total_pages"`
+}
+
+// FindPage returns the page-th page, counting from 1, of size entities matching filter.
+// A page beyond the last one has no items.
+func (c *CrudRepository[ID, ENTITY]) FindPage(ctx context.Context, filter map[string]any, page, size int, orders ...contract.Order) (result *PageResult[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v, %v", filter, page, size, orders) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	if page < 1 || size < 1 {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid page %d or size %d", page, size)))
+	}
+	total, err := c.CountByFilter(ctx, filter)
+	errors.Check(err)
+
+	result = &PageResult[ID, ENTITY]{
+		Items:      []ENTITY{},
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: (total + size - 1) / size,
+	}
+	if page > result.TotalPages {
+		return
+	}
+	collection, err := c.FindByFilterWithPage(ctx, filter, size, (page-1)*size, orders...)
+	errors.Check(err)
+	result.Items = collection.All()
+	return
+}
+
+// FindPageFacet is like FindPage, reading the page and the total in a single `$facet` aggregation
+// instead of a count and a find, so both come from the same snapshot in one round trip.
+func (c *CrudRepository[ID, ENTITY]) FindPageFacet(ctx context.Context, filter map[string]any, page, size int, orders ...contract.Order) (result *PageResult[ID, ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v, %v", filter, page, size, orders) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	if page < 1 || size < 1 {
+		errors.Check(ErrInvalidArgument.WrapStack(errors.NewWithMessage("invalid page %d or size %d", page, size)))
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: c.buildFilter(filter)}}}
+	if len(orders) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: OrdersToSort(orders)}})
+	}
+	items := bson.A{
+		bson.M{"$skip": int64((page - 1) * size)},
+		bson.M{"$limit": int64(size)},
+	}
+	for _, stage := range c.projectionStages() {
+		items = append(items, stage)
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: bson.M{
+		"items": items,
+		"total": bson.A{bson.M{"$count": "count"}},
+	}}})
+	cursor, err := c.collection.Aggregate(ctx, pipeline, c.newAggregateOptions())
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	var facet struct {
+		Items []ENTITY `bson:"items"`
+		Total []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+	}
+	if cursor.Next(ctx) {
+		errors.Check(mapError(cursor.Decode(&facet)))
+	}
+	errors.Check(mapError(cursor.Err()))
+
+	result = &PageResult[ID, ENTITY]{
+		Items: facet.Items,
+		Page:  page,
+		Size:  size,
+	}
+	if result.Items == nil {
+		result.Items = []ENTITY{}
+	}
+	if len(facet.Total) > 0 {
+		result.Total = facet.Total[0].Count
+	}
+	result.TotalPages = (result.Total + size - 1) / size
+	return
+}
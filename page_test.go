@@ -0,0 +1,107 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository/contract"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"log"
+	"testing"
+)
+
+func TestCrudRepository_FindPage(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindPage err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	ids := make([]int64, 0, 6)
+	for i := 0; i < 6; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[5])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	ids = ids[:5]
+	order := contract.Order{Key: "_id", Value: 1}
+	pageIDs := func(result *PageResult[int64, *UserSoftDelete]) []int64 {
+		pageIDs := make([]int64, 0, len(result.Items))
+		for _, item := range result.Items {
+			pageIDs = append(pageIDs, item.ID)
+		}
+		return pageIDs
+	}
+
+	result, err := userRepository.FindPage(context.Background(), map[string]any{"name": "test"}, 1, 2, order)
+	errors.Check(errors.Wrap(err, "failed to find page"))
+	assert.Equal(t, pageIDs(result), ids[:2])
+	assert.Equal(t, result.Total, 5)
+	assert.Equal(t, result.Page, 1)
+	assert.Equal(t, result.Size, 2)
+	assert.Equal(t, result.TotalPages, 3)
+
+	result, err = userRepository.FindPage(context.Background(), map[string]any{"name": "test"}, 2, 2, order)
+	errors.Check(errors.Wrap(err, "failed to find page"))
+	assert.Equal(t, pageIDs(result), ids[2:4])
+
+	result, err = userRepository.FindPage(context.Background(), map[string]any{"name": "test"}, 4, 2, order)
+	errors.Check(errors.Wrap(err, "failed to find page"))
+	assert.Equal(t, result.Items, []*UserSoftDelete{})
+	assert.Equal(t, result.Total, 5)
+	assert.Equal(t, result.TotalPages, 3)
+
+	_, err = userRepository.FindPage(context.Background(), nil, 0, 2)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_FindPageFacet(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_FindPageFacet err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+
+	ids := make([]int64, 0, 6)
+	for i := 0; i < 6; i++ {
+		id, err := userRepository.Create(context.Background(), &UserSoftDelete{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+		ids = append(ids, id)
+	}
+	err := userRepository.DeleteByID(context.Background(), ids[5])
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+	ids = ids[:5]
+	order := contract.Order{Key: "_id", Value: -1}
+
+	result, err := userRepository.FindPageFacet(context.Background(), map[string]any{"name": "test"}, 2, 2, order)
+	errors.Check(errors.Wrap(err, "failed to find page"))
+	assert.Equal(t, len(result.Items), 2)
+	assert.Equal(t, result.Items[0].ID, ids[2])
+	assert.Equal(t, result.Items[1].ID, ids[1])
+	assert.Equal(t, result.Total, 5)
+	assert.Equal(t, result.Page, 2)
+	assert.Equal(t, result.Size, 2)
+	assert.Equal(t, result.TotalPages, 3)
+
+	result, err = userRepository.With(WithDefaultProjection(bson.D{{Key: "name", Value: 0}})).FindPageFacet(context.Background(), map[string]any{"name": "test"}, 1, 2, order)
+	errors.Check(errors.Wrap(err, "failed to find page"))
+	assert.Equal(t, len(result.Items), 2)
+	assert.Equal(t, result.Items[0].ID, ids[4])
+	assert.Equal(t, result.Items[0].Name, "")
+	assert.Equal(t, result.Total, 5)
+
+	result, err = userRepository.FindPageFacet(context.Background(), map[string]any{"name": "test2"}, 1, 2)
+	errors.Check(errors.Wrap(err, "failed to find page"))
+	assert.Equal(t, result.Items, []*UserSoftDelete{})
+	assert.Equal(t, result.Total, 0)
+	assert.Equal(t, result.TotalPages, 0)
+
+	_, err = userRepository.FindPageFacet(context.Background(), nil, 1, 0)
+	assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+}
@@ -0,0 +1,41 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"testing"
+)
+
+func TestCrudRepository_Session(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Session err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"), WithBatchConcurrency(4))
+
+	session, err := db.Client().StartSession(options.Session().SetCausalConsistency(true))
+	errors.Check(errors.Wrap(err, "failed to start session"))
+	defer session.EndSession(context.Background())
+
+	err = mongo.WithSession(context.Background(), session, func(ctx mongo.SessionContext) error {
+		user := User{
+			ID:   idGen.Generate(),
+			Name: "test",
+		}
+		_, err := userRepository.Create(ctx, &user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+
+		user1, err := userRepository.FindByID(ctx, user.ID)
+		errors.Check(errors.Wrap(err, "failed to find user"))
+		assert.Equal(t, user1.Name, user.Name)
+
+		collection, err := userRepository.FindByIDs(ctx, []int64{user.ID})
+		errors.Check(errors.Wrap(err, "failed to find user"))
+		assert.Equal(t, collection.Has(user.ID), true)
+		return nil
+	})
+	errors.Check(err)
+}
@@ -0,0 +1,119 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var tailRetryInterval = 100 * time.Millisecond
+
+func (c *CrudRepository[ID, ENTITY]) isCapped(ctx context.Context) (capped bool, err error) {
+	defer errors.Recover(func(e error) { err = e })
+	specs, err := c.collection.Database().ListCollectionSpecifications(ctx, bson.M{"name": c.collection.Name()})
+	errors.Check(mapError(err))
+	if len(specs) == 0 || specs[0].Options == nil {
+		return false, nil
+	}
+	capped, _ = specs[0].Options.Lookup("capped").BooleanOK()
+	return
+}
+
+// Tail calls fn for the matched documents of a capped collection, then for every new one as it is inserted,
+// until ctx is done, in which case it returns nil. Tailing resumes after the last seen id if the cursor dies,
+// so ids are expected to increase.
+func (c *CrudRepository[ID, ENTITY]) Tail(ctx context.Context, filter map[string]any, fn func(ENTITY) error) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	capped, err := c.isCapped(ctx)
+	errors.Check(err)
+	if !capped {
+		errors.Check(ErrNotCapped.WrapStack(errors.NewWithStack("collection: %s", c.collection.Name())))
+	}
+
+	opts := c.newFindOptions().SetCursorType(options.TailableAwait)
+	var (
+		lastID ID
+		seen   bool
+	)
+	for ctx.Err() == nil {
+		query := c.buildFilter(filter)
+		if seen {
+			query = append(query, bson.E{Key: c.idField, Value: bson.M{"$gt": lastID}})
+		}
+		cursor, err := c.collection.Find(ctx, query, opts)
+		if ctx.Err() != nil {
+			return nil
+		}
+		errors.Check(mapError(err))
+		for cursor.Next(ctx) {
+			var entity ENTITY
+			err = cursor.Decode(&entity)
+			if err == nil {
+				lastID, seen = entity.GetID(), true
+				err = fn(entity)
+			}
+			if err != nil {
+				_ = cursor.Close(context.Background())
+				errors.Check(mapError(err))
+			}
+		}
+		err = cursor.Err()
+		_ = cursor.Close(context.Background())
+		if ctx.Err() != nil {
+			return nil
+		}
+		errors.Check(mapError(err))
+
+		// the cursor died, e.g. because nothing matched yet
+		select {
+		case <-ctx.Done():
+		case <-time.After(tailRetryInterval):
+		}
+	}
+	return nil
+}
+
+// Stream sends the matched entities on the returned channel, which has a buffer of bufSize and is closed when
+// the cursor is exhausted, the first error occurs or ctx is done. The error, if any, is sent on the error channel.
+// A negative bufSize sends ErrInvalidArgument without querying.
+func (c *CrudRepository[ID, ENTITY]) Stream(ctx context.Context, filter map[string]any, bufSize int) (<-chan ENTITY, <-chan error) {
+	errs := make(chan error, 1)
+	if bufSize < 0 {
+		entities := make(chan ENTITY)
+		close(entities)
+		errs <- ErrInvalidArgument.WrapStack(errors.NewWithMessage("negative buffer size: %d", bufSize))
+		close(errs)
+		return entities, errs
+	}
+	entities := make(chan ENTITY, bufSize)
+	go func() {
+		defer close(errs)
+		defer close(entities)
+		if err := c.stream(ctx, filter, entities); err != nil {
+			errs <- err
+		}
+	}()
+	return entities, errs
+}
+
+func (c *CrudRepository[ID, ENTITY]) stream(ctx context.Context, filter map[string]any, entities chan<- ENTITY) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", filter) })
+	cursor, err := c.collection.Find(ctx, c.buildFilter(filter), c.findOptions())
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		errors.Check(errors.WithStack(ctx.Err()))
+		var entity ENTITY
+		errors.Check(mapError(cursor.Decode(&entity)))
+		select {
+		case entities <- entity:
+		case <-ctx.Done():
+			errors.Check(errors.WithStack(ctx.Err()))
+		}
+	}
+	errors.Check(mapError(cursor.Err()))
+	return
+}
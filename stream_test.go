@@ -0,0 +1,116 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestCrudRepository_Tail(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Tail err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	err := db.CreateCollection(context.Background(), "log", options.CreateCollection().SetCapped(true).SetSizeInBytes(1<<20))
+	errors.Check(errors.Wrap(err, "failed to create capped collection"))
+	logRepository := NewCrudRepository[int64, *User](db.Collection("log"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	received := make(chan *User, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- logRepository.Tail(ctx, nil, func(user *User) error {
+			received <- user
+			cancel()
+			return nil
+		})
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	_, err = logRepository.Create(context.Background(), &User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	})
+	errors.Check(errors.Wrap(err, "failed to create log"))
+
+	errors.Check(errors.Wrap(<-done, "failed to tail"))
+	assert.Equal(t, (<-received).Name, "test")
+}
+
+func TestCrudRepository_Tail_NotCapped(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Tail_NotCapped err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	err := db.CreateCollection(context.Background(), "user")
+	errors.Check(errors.Wrap(err, "failed to create collection"))
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	err = userRepository.Tail(context.Background(), nil, func(user *User) error {
+		return nil
+	})
+	assert.Equal(t, errors.Is(err, ErrNotCapped), true)
+}
+
+func TestCrudRepository_Stream(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Stream err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	for i := 0; i < 10; i++ {
+		_, err := userRepository.Create(context.Background(), &User{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	entities, errs := userRepository.Stream(context.Background(), map[string]any{"name": "test"}, 2)
+	count := 0
+	for user := range entities {
+		assert.Equal(t, user.Name, "test")
+		count++
+	}
+	errors.Check(errors.Wrap(<-errs, "failed to stream user"))
+	assert.Equal(t, count, 10)
+}
+
+func TestCrudRepository_Stream_NegativeBufSize(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Stream_NegativeBufSize err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	entities, errs := userRepository.Stream(context.Background(), nil, -1)
+	_, ok := <-entities
+	assert.Equal(t, ok, false)
+	assert.Equal(t, errors.Is(<-errs, ErrInvalidArgument), true)
+}
+
+func TestCrudRepository_Stream_Cancel(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Stream_Cancel err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	for i := 0; i < 10; i++ {
+		_, err := userRepository.Create(context.Background(), &User{
+			ID:   idGen.Generate(),
+			Name: "test",
+		})
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entities, errs := userRepository.Stream(ctx, nil, 0)
+	<-entities
+	cancel()
+	count := 1
+	for range entities {
+		count++
+	}
+	assert.Equal(t, count < 10, true)
+	assert.Equal(t, errors.Is(<-errs, context.Canceled), true)
+}
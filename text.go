@@ -0,0 +1,50 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// codeIndexNotFound is returned for a `$text` query on a collection without a text index.
+const codeIndexNotFound = 27
+
+// textScoreField is the field SearchText projects the text score into.
+const textScoreField = "_score"
+
+// TextResult is an entity found by SearchText along with its relevance score.
+type TextResult[ENTITY any] struct {
+	Entity ENTITY
+	Score  float64
+}
+
+// SearchText finds the entities matching filter whose text index matches search, most relevant first.
+// A zero limit means no limit. It fails with ErrTextIndexRequired if the collection has no text index.
+func (c *CrudRepository[ID, ENTITY]) SearchText(ctx context.Context, search string, filter map[string]any, limit int) (results []TextResult[ENTITY], err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v, %v, %v", search, filter, limit) })
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	score := bson.M{"$meta": "textScore"}
+	opts := c.newFindOptions().
+		SetProjection(bson.D{{Key: textScoreField, Value: score}}).
+		SetSort(bson.D{{Key: textScoreField, Value: score}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	query := append(c.buildFilter(filter), bson.E{Key: "$text", Value: bson.M{"$search": search}})
+	cursor, err := c.collection.Find(ctx, query, opts)
+	if hasErrorCode(err, codeIndexNotFound) {
+		errors.Check(ErrTextIndexRequired.WrapStack(err))
+	}
+	errors.Check(mapError(err))
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var result TextResult[ENTITY]
+		errors.Check(mapError(cursor.Decode(&result.Entity)))
+		result.Score = cursor.Current.Lookup(textScoreField).Double()
+		results = append(results, result)
+	}
+	errors.Check(mapError(cursor.Err()))
+	return
+}
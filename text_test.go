@@ -0,0 +1,45 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"testing"
+)
+
+func TestCrudRepository_SearchText(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_SearchText err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *UserSoftDelete](db.Collection("user"))
+	users := []*UserSoftDelete{
+		{ID: idGen.Generate(), Name: "apple"},
+		{ID: idGen.Generate(), Name: "apple banana apple apple"},
+		{ID: idGen.Generate(), Name: "apple apple banana"},
+		{ID: idGen.Generate(), Name: "cherry"},
+	}
+	for _, user := range users {
+		_, err := userRepository.Create(context.Background(), user)
+		errors.Check(errors.Wrap(err, "failed to create user"))
+	}
+
+	_, err := userRepository.SearchText(context.Background(), "apple", nil, 0)
+	assert.Equal(t, errors.Is(err, ErrTextIndexRequired), true)
+
+	_, err = db.Collection("user").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: "text"}},
+	})
+	errors.Check(errors.Wrap(err, "failed to create text index"))
+	err = userRepository.DeleteByID(context.Background(), users[1].ID)
+	errors.Check(errors.Wrap(err, "failed to delete user"))
+
+	results, err := userRepository.SearchText(context.Background(), "apple", nil, 0)
+	errors.Check(errors.Wrap(err, "failed to search user"))
+	assert.Equal(t, len(results), 2)
+	assert.Equal(t, results[0].Entity.ID, users[2].ID)
+	assert.Equal(t, results[1].Entity.ID, users[0].ID)
+	assert.Equal(t, results[0].Score > results[1].Score, true)
+}
@@ -0,0 +1,59 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+)
+
+// Transaction runs fn in a transaction on a new session of the collection's client, committing it if fn
+// returns nil and aborting it otherwise. The repository methods called with the ctx passed to fn join it.
+// With WithTransactionRetries, the transaction is run again when it fails with a TransientTransactionError,
+// and the commit is retried on an UnknownTransactionCommitResult, so fn may run several times and must be
+// idempotent: it should only change state through ctx, not e.g. by appending to outer variables.
+func (c *CrudRepository[ID, ENTITY]) Transaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	return runInTransaction(ctx, c.collection.Database().Client(), c.config.transactionRetries, fn)
+}
+
+// RunInTransaction is like Transaction, on a new session of client, for fn to call several repositories,
+// e.g. of different collections, that all join the transaction through the ctx passed to fn.
+// Each repository must be backed by client: a session cannot be used with another client.
+// Transient errors are not retried.
+func RunInTransaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	return runInTransaction(ctx, client, 0, fn)
+}
+
+func runInTransaction(ctx context.Context, client *mongo.Client, retries int, fn func(ctx context.Context) error) (err error) {
+	defer errors.Recover(func(e error) { err = e })
+	session, err := client.StartSession()
+	errors.Check(mapError(err))
+	defer session.EndSession(context.Background())
+
+	for attempt := 0; ; attempt++ {
+		err = runTransaction(ctx, session, retries, fn)
+		if err == nil || attempt >= retries || !hasErrorLabel(err, driver.TransientTransactionError) {
+			break
+		}
+	}
+	errors.Check(mapError(err))
+	return
+}
+
+func runTransaction(ctx context.Context, session mongo.Session, retries int, fn func(ctx context.Context) error) error {
+	if err := session.StartTransaction(); err != nil {
+		return err
+	}
+	return mongo.WithSession(ctx, session, func(ctx mongo.SessionContext) error {
+		if err := fn(ctx); err != nil {
+			_ = session.AbortTransaction(context.Background())
+			return err
+		}
+		for attempt := 0; ; attempt++ {
+			err := session.CommitTransaction(ctx)
+			if err == nil || attempt >= retries || !hasErrorLabel(err, driver.UnknownTransactionCommitResult) {
+				return err
+			}
+		}
+	})
+}
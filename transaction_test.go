@@ -0,0 +1,109 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"testing"
+)
+
+func TestCrudRepository_Transaction(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Transaction err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	user := User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+
+	err := userRepository.Transaction(context.Background(), func(ctx context.Context) error {
+		_, err := userRepository.Create(ctx, &user)
+		return err
+	})
+	if errors.Is(err, ErrReplicaSetRequired) {
+		t.Skip("transactions require a replica set")
+	}
+	errors.Check(errors.Wrap(err, "failed to run transaction"))
+	exists, err := userRepository.ExistsByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, true)
+
+	err = userRepository.Transaction(context.Background(), func(ctx context.Context) error {
+		errors.Check(userRepository.DeleteByID(ctx, user.ID))
+		return errors.New("rollback")
+	})
+	assert.Equal(t, err.Error(), "rollback")
+	exists, err = userRepository.ExistsByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, true)
+}
+
+func TestCrudRepository_Transaction_Retry(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Transaction_Retry err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	conflict := mongo.CommandError{Code: 112, Name: "WriteConflict", Labels: []string{"TransientTransactionError"}}
+
+	attempts := 0
+	fn := func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return conflict
+		}
+		return nil
+	}
+	err := NewCrudRepository[int64, *User](db.Collection("user")).Transaction(context.Background(), fn)
+	assert.Equal(t, hasErrorLabel(err, "TransientTransactionError"), true)
+	assert.Equal(t, attempts, 1)
+
+	attempts = 0
+	err = NewCrudRepository[int64, *User](db.Collection("user"), WithTransactionRetries(2)).Transaction(context.Background(), fn)
+	errors.Check(errors.Wrap(err, "failed to run transaction"))
+	assert.Equal(t, attempts, 2)
+}
+
+func TestRunInTransaction(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestRunInTransaction err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+	roleRepository := NewCrudRepository[int64, *Role](db.Collection("role"))
+	user := User{ID: idGen.Generate(), Name: "test"}
+	role := Role{ID: idGen.Generate(), Name: "admin"}
+
+	err := RunInTransaction(context.Background(), db.Client(), func(ctx context.Context) error {
+		if _, err := userRepository.Create(ctx, &user); err != nil {
+			return err
+		}
+		_, err := roleRepository.Create(ctx, &role)
+		return err
+	})
+	if errors.Is(err, ErrReplicaSetRequired) {
+		t.Skip("transactions require a replica set")
+	}
+	errors.Check(errors.Wrap(err, "failed to run transaction"))
+	exists, err := userRepository.ExistsByID(context.Background(), user.ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, true)
+	exists, err = roleRepository.ExistsByID(context.Background(), role.ID)
+	errors.Check(errors.Wrap(err, "failed to check role"))
+	assert.Equal(t, exists, true)
+
+	user2 := User{ID: idGen.Generate(), Name: "test2"}
+	err = RunInTransaction(context.Background(), db.Client(), func(ctx context.Context) error {
+		errors.Check(roleRepository.UpdateByID(ctx, role.ID, map[string]any{"name": "guest"}))
+		_, err := userRepository.Create(ctx, &user2)
+		errors.Check(err)
+		return errors.New("rollback")
+	})
+	assert.Equal(t, err.Error(), "rollback")
+	exists, err = userRepository.ExistsByID(context.Background(), user2.ID)
+	errors.Check(errors.Wrap(err, "failed to check user"))
+	assert.Equal(t, exists, false)
+	role2, err := roleRepository.FindByID(context.Background(), role.ID)
+	errors.Check(errors.Wrap(err, "failed to find role"))
+	assert.Equal(t, role2.Name, "admin")
+}
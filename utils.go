@@ -1,12 +1,17 @@
 package repositorymongo
 
 import (
+	"github.com/ace-zhaoy/errors"
 	"github.com/ace-zhaoy/go-repository/contract"
 	"github.com/ace-zhaoy/go-utils/ucondition"
+	"github.com/ace-zhaoy/go-utils/umap"
 	"github.com/ace-zhaoy/go-utils/uslice"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 func getIDField(entity any) string {
@@ -19,22 +24,18 @@ func getIDField(entity any) string {
 		panic("entity must be a struct")
 	}
 
-	field, found := t.FieldByName("ID")
+	prefix, field, found := lookupField(t, "ID")
 	if !found {
-		field, found = t.FieldByName("Id")
+		prefix, field, found = lookupField(t, "Id")
 		if !found {
 			panic("entity must have field `ID` or `Id`")
 		}
 	}
-	tag := field.Tag.Get("bson")
-	if tag == "" {
-		tag = field.Tag.Get("json")
-	}
-	if tag != "" {
-		return strings.Split(tag, ",")[0]
+	if name := tagName(field); name != "" {
+		return prefix + name
 	}
 
-	return "_id"
+	return prefix + "_id"
 }
 
 func getDeletedAtField(entity any) string {
@@ -47,20 +48,137 @@ func getDeletedAtField(entity any) string {
 		panic("entity must be a struct")
 	}
 
-	field, found := t.FieldByName("DeletedAt")
+	prefix, field, found := lookupField(t, "DeletedAt")
 	if !found {
 		return ""
 	}
 
-	tag := field.Tag.Get("bson")
-	if tag == "" {
-		tag = field.Tag.Get("json")
+	if name := tagName(field); name != "" {
+		return prefix + name
+	}
+
+	return prefix + "deleted_at"
+}
+
+// getDeletedAtType returns the type of the DeletedAt field of entity, or nil if it has none.
+func getDeletedAtType(entity any) reflect.Type {
+	t := reflect.TypeOf(entity)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, field, found := lookupField(t, "DeletedAt"); found {
+		return field.Type
+	}
+	return nil
+}
+
+// isDateType reports whether values of t are stored as BSON dates.
+func isDateType(t reflect.Type) bool {
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == reflect.TypeOf(time.Time{}) || t == reflect.TypeOf(primitive.DateTime(0))
+}
+
+// lookupField finds the named field in t, walking embedded structs. prefix is the dotted path of
+// the embedded documents holding the field, empty when the field is stored inline.
+func lookupField(t reflect.Type, name string) (prefix string, field reflect.StructField, found bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if field = t.Field(i); field.Name == name && !field.Anonymous {
+			return "", field, true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		embedded := t.Field(i)
+		et := embeddedStruct(embedded)
+		if et == nil {
+			continue
+		}
+		if prefix, field, found = lookupField(et, name); found {
+			if !isInline(embedded) {
+				prefix = embeddedName(embedded) + "." + prefix
+			}
+			return
+		}
+	}
+	return "", reflect.StructField{}, false
+}
+
+// embeddedStruct returns the struct type of an embedded field, or nil if field is not an embedded struct.
+func embeddedStruct(field reflect.StructField) reflect.Type {
+	if !field.Anonymous {
+		return nil
+	}
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+func isInline(field reflect.StructField) bool {
+	return uslice.Contains(strings.Split(field.Tag.Get("bson"), ",")[1:], "inline")
+}
+
+// embeddedName returns the document key of a non-inline embedded struct, following the driver's default.
+func embeddedName(field reflect.StructField) string {
+	if name := tagName(field); name != "" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+// setZeroDeletedAt sets the DeletedAt field of entity to value if the field is zero.
+func setZeroDeletedAt(entity any, value any) {
+	v := reflect.ValueOf(entity)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
 	}
-	if tag != "" {
-		return strings.Split(tag, ",")[0]
+	structField, found := v.Elem().Type().FieldByName("DeletedAt")
+	if !found {
+		return
+	}
+	field, err := v.Elem().FieldByIndexErr(structField.Index)
+	val := reflect.ValueOf(value)
+	if err != nil || !field.CanSet() || !field.IsZero() || !val.IsValid() {
+		return
+	}
+	numeric := (val.CanInt() || val.CanUint() || val.CanFloat()) && (field.CanInt() || field.CanUint() || field.CanFloat())
+	if !numeric && !val.Type().AssignableTo(field.Type()) {
+		return
+	}
+	field.Set(val.Convert(field.Type()))
+}
+
+// isNil reports whether v is nil or a nil pointer, such as a nil *User entity.
+func isNil(v any) bool {
+	if v == nil {
+		return true
 	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
 
-	return "deleted_at"
+// isIncluded reports whether v, the value of a projection field, includes the field: a non-zero number, true,
+// or an expression. A zero number, false and nil exclude it.
+func isIncluded(v any) bool {
+	rv := reflect.ValueOf(v)
+	switch {
+	case !rv.IsValid():
+		return false
+	case rv.Kind() == reflect.Bool:
+		return rv.Bool()
+	case rv.CanInt():
+		return rv.Int() != 0
+	case rv.CanUint():
+		return rv.Uint() != 0
+	case rv.CanFloat():
+		return rv.Float() != 0
+	}
+	return true
 }
 
 func OrdersToSort(orders []contract.Order) bson.D {
@@ -72,26 +190,108 @@ func OrdersToSort(orders []contract.Order) bson.D {
 	})
 }
 
+// ParseOrders parses a sort string such as "name,-created_at", e.g. from a query parameter,
+// where a leading "-" sorts a key in descending order and a leading "+" in ascending order.
+// An empty string has no orders; an empty key, as in "name,,-id" or a trailing comma, fails with ErrInvalidArgument.
+func ParseOrders(s string) (orders []contract.Order, err error) {
+	if strings.TrimSpace(s) == "" {
+		return []contract.Order{}, nil
+	}
+	orders = make([]contract.Order, 0, strings.Count(s, ",")+1)
+	for _, segment := range strings.Split(s, ",") {
+		segment = strings.TrimSpace(segment)
+		order := contract.Order{Key: strings.TrimLeft(segment, "+-"), Value: 1}
+		if strings.HasPrefix(segment, "-") {
+			order.Value = -1
+		}
+		if order.Key == "" {
+			return nil, ErrInvalidArgument.WrapStack(errors.NewWithMessage("empty sort key in %q", s))
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// tagName returns the field name declared by the bson tag, falling back to the json tag.
+func tagName(field reflect.StructField) string {
+	for _, key := range []string{"bson", "json"} {
+		if name := strings.Split(field.Tag.Get(key), ",")[0]; name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// fieldName returns the document key of a struct field.
+func fieldName(field reflect.StructField) string {
+	if name := tagName(field); name != "" {
+		return name
+	}
+	return field.Name
+}
+
 func getNonZeroFields(data any) bson.M {
+	return StructToSet(data, false)
+}
+
+// StructToSet builds a `$set` document from the exported fields of entity, keyed by document key.
+// Zero fields are skipped unless includeZero is true; if only is given, other fields are skipped too.
+// A nil entity, or a nil embedded struct pointer, contributes no fields.
+func StructToSet(entity any, includeZero bool, only ...string) bson.M {
 	result := bson.M{}
-	v := reflect.ValueOf(data)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		if !field.IsZero() {
-			tag := v.Type().Field(i).Tag
-			fieldName := tag.Get("bson")
-			if fieldName == "" {
-				fieldName = tag.Get("json")
-				if fieldName == "" {
-					fieldName = v.Type().Field(i).Name
-				}
-			}
-			fieldName = strings.Split(fieldName, ",")[0]
-			result[fieldName] = field.Interface()
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if v.Kind() != reflect.Struct {
+		return result
+	}
+	for _, f := range structFieldsOf(v.Type()) {
+		field := v.Field(f.index)
+		if f.inline {
+			umap.Foreach(StructToSet(field.Interface(), includeZero, only...), func(key string, value any) {
+				result[key] = value
+			})
+			continue
+		}
+		if len(only) > 0 && !uslice.Contains(only, f.name) {
+			continue
 		}
+		if !includeZero && field.IsZero() {
+			continue
+		}
+		result[f.name] = field.Interface()
 	}
 	return result
 }
+
+// structField is the metadata of a struct field StructToSet writes.
+type structField struct {
+	index  int
+	name   string
+	inline bool
+}
+
+// structFieldsCache maps a struct type to its []structField.
+var structFieldsCache sync.Map
+
+// structFieldsOf returns the fields of t StructToSet writes, computed once per type:
+// exported fields not tagged "-", and inline embedded structs.
+func structFieldsOf(t reflect.Type) []structField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if embeddedStruct(field) != nil && isInline(field) {
+			fields = append(fields, structField{index: i, inline: true})
+			continue
+		}
+		if name := fieldName(field); name != "-" {
+			fields = append(fields, structField{index: i, name: name})
+		}
+	}
+	actual, _ := structFieldsCache.LoadOrStore(t, fields)
+	return actual.([]structField)
+}
@@ -0,0 +1,143 @@
+package repositorymongo
+
+import (
+	"github.com/ace-zhaoy/errors"
+	"github.com/ace-zhaoy/go-repository/contract"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"sync"
+	"testing"
+)
+
+type Article struct {
+	ID      int64  `json:"id" bson:"_id"`
+	Title   string `json:"title" bson:"title"`
+	Content string `json:"content"`
+	Views   int64
+	Ignored string `bson:"-"`
+	secret  string
+}
+
+func TestStructToSet(t *testing.T) {
+	article := &Article{
+		ID:      1,
+		Title:   "title",
+		Ignored: "ignored",
+		secret:  "secret",
+	}
+	assert.Equal(t, StructToSet(article, false), bson.M{
+		"_id":   int64(1),
+		"title": "title",
+	})
+	assert.Equal(t, StructToSet(article, true), bson.M{
+		"_id":     int64(1),
+		"title":   "title",
+		"content": "",
+		"Views":   int64(0),
+	})
+}
+
+func TestStructToSet_Only(t *testing.T) {
+	article := Article{
+		ID:    1,
+		Title: "title",
+	}
+	assert.Equal(t, StructToSet(article, false, "title", "content"), bson.M{
+		"title": "title",
+	})
+	assert.Equal(t, StructToSet(article, true, "title", "content"), bson.M{
+		"title":   "title",
+		"content": "",
+	})
+}
+
+func TestStructToSet_Cached(t *testing.T) {
+	type Comment struct {
+		Article `bson:",inline"`
+		Body    string `bson:"body"`
+	}
+	want := bson.M{
+		"_id":   int64(1),
+		"title": "title",
+		"body":  "body",
+	}
+	comment := Comment{
+		Article: Article{ID: 1, Title: "title"},
+		Body:    "body",
+	}
+	var wg sync.WaitGroup
+	results := make([]bson.M, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = StructToSet(comment, false)
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range results {
+		assert.Equal(t, result, want)
+	}
+	assert.Equal(t, StructToSet(comment, false), want)
+}
+
+func TestStructToSet_NilPointers(t *testing.T) {
+	type Meta struct {
+		Source string `bson:"source"`
+	}
+	type Comment struct {
+		*Article `bson:",inline"`
+		*Meta    `bson:"meta"`
+		Body     string `bson:"body"`
+	}
+	comment := &Comment{Body: "body"}
+	assert.Equal(t, StructToSet(comment, false), bson.M{"body": "body"})
+	assert.Equal(t, StructToSet(comment, true), bson.M{"meta": (*Meta)(nil), "body": "body"})
+
+	comment.Article = &Article{ID: 1}
+	comment.Meta = &Meta{Source: "web"}
+	assert.Equal(t, StructToSet(comment, false), bson.M{"_id": int64(1), "meta": comment.Meta, "body": "body"})
+
+	assert.Equal(t, StructToSet((*Comment)(nil), false), bson.M{})
+	assert.Equal(t, StructToSet(nil, true), bson.M{})
+}
+
+func BenchmarkStructToSet(b *testing.B) {
+	article := &Article{
+		ID:      1,
+		Title:   "title",
+		Content: "content",
+		Views:   1,
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		StructToSet(article, false)
+	}
+}
+
+func TestParseOrders(t *testing.T) {
+	orders, err := ParseOrders("name,-created_at")
+	errors.Check(err)
+	assert.Equal(t, orders, []contract.Order{
+		{Key: "name", Value: 1},
+		{Key: "created_at", Value: -1},
+	})
+	orders, err = ParseOrders(" -score , +name, id")
+	errors.Check(err)
+	assert.Equal(t, orders, []contract.Order{
+		{Key: "score", Value: -1},
+		{Key: "name", Value: 1},
+		{Key: "id", Value: 1},
+	})
+	orders, err = ParseOrders("")
+	errors.Check(err)
+	assert.Equal(t, orders, []contract.Order{})
+	orders, err = ParseOrders("name,-_id")
+	errors.Check(err)
+	assert.Equal(t, OrdersToSort(orders), bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: -1}})
+
+	for _, s := range []string{"name,,-id", "name,", ",name", "name,-", " , "} {
+		_, err = ParseOrders(s)
+		assert.Equal(t, errors.Is(err, ErrInvalidArgument), true)
+	}
+}
@@ -0,0 +1,96 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var watchRetryInterval = time.Second
+
+// Watch opens a change stream on the collection and calls fn for every change event until ctx is done,
+// in which case it returns nil. After a network failure the stream resumes from the last seen event.
+// Change streams require a replica set, otherwise ErrReplicaSetRequired is returned.
+func (c *CrudRepository[ID, ENTITY]) Watch(ctx context.Context, pipeline mongo.Pipeline, fn func(changeEvent bson.M) error) (err error) {
+	defer errors.Recover(func(e error) { err = errors.Wrap(e, "param: %v", pipeline) })
+	return c.watch(ctx, pipeline, options.ChangeStream(), func(stream *mongo.ChangeStream) error {
+		var changeEvent bson.M
+		if err := stream.Decode(&changeEvent); err != nil {
+			return err
+		}
+		return fn(changeEvent)
+	})
+}
+
+// WatchEntities is like Watch, and decodes the changed document into an entity. op is the operation type,
+// such as insert, update, replace or delete. For delete events the entity only holds the document key.
+func (c *CrudRepository[ID, ENTITY]) WatchEntities(ctx context.Context, fn func(op string, entity ENTITY) error) (err error) {
+	defer errors.Recover(func(e error) { err = e })
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	return c.watch(ctx, nil, opts, func(stream *mongo.ChangeStream) error {
+		var changeEvent struct {
+			OperationType string   `bson:"operationType"`
+			FullDocument  bson.Raw `bson:"fullDocument"`
+			DocumentKey   bson.Raw `bson:"documentKey"`
+		}
+		if err := stream.Decode(&changeEvent); err != nil {
+			return err
+		}
+		document := changeEvent.FullDocument
+		if len(document) == 0 {
+			document = changeEvent.DocumentKey
+		}
+		if len(document) == 0 {
+			// collection level events such as drop or invalidate
+			return nil
+		}
+		var entity ENTITY
+		if err := bson.UnmarshalWithRegistry(c.registry, document, &entity); err != nil {
+			return err
+		}
+		return fn(changeEvent.OperationType, entity)
+	})
+}
+
+func (c *CrudRepository[ID, ENTITY]) watch(ctx context.Context, pipeline mongo.Pipeline, opts *options.ChangeStreamOptions, fn func(stream *mongo.ChangeStream) error) (err error) {
+	defer errors.Recover(func(e error) { err = e })
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+	var resumeToken bson.Raw
+	for ctx.Err() == nil {
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+		stream, err := c.collection.Watch(ctx, pipeline, opts)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			for stream.Next(ctx) {
+				if err = fn(stream); err != nil {
+					_ = stream.Close(context.Background())
+					errors.Check(mapError(err))
+				}
+				resumeToken = stream.ResumeToken()
+			}
+			err = stream.Err()
+			_ = stream.Close(context.Background())
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+		if !mongo.IsNetworkError(err) {
+			errors.Check(mapError(err))
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(watchRetryInterval):
+		}
+	}
+	return nil
+}
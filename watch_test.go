@@ -0,0 +1,96 @@
+package repositorymongo
+
+import (
+	"context"
+	"github.com/ace-zhaoy/errors"
+	"github.com/magiconair/properties/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestCrudRepository_Watch(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_Watch err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	received := make(chan bson.M, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- userRepository.Watch(ctx, nil, func(changeEvent bson.M) error {
+			received <- changeEvent
+			cancel()
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if errors.Is(err, ErrReplicaSetRequired) {
+			t.Skip("change streams require a replica set")
+		}
+		errors.Check(errors.Wrap(err, "failed to watch"))
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	user := User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	errors.Check(errors.Wrap(<-done, "failed to watch"))
+	changeEvent := <-received
+	assert.Equal(t, changeEvent["operationType"], "insert")
+	assert.Equal(t, changeEvent["documentKey"], bson.M{"_id": user.ID})
+}
+
+func TestCrudRepository_WatchEntities(t *testing.T) {
+	defer errors.Recover(func(e error) { log.Fatalf("TestCrudRepository_WatchEntities err: %+v", e) })
+	db, teardown := getDatabase()
+	defer teardown()
+	userRepository := NewCrudRepository[int64, *User](db.Collection("user"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	type change struct {
+		op   string
+		user *User
+	}
+	received := make(chan change, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- userRepository.WatchEntities(ctx, func(op string, user *User) error {
+			received <- change{op: op, user: user}
+			cancel()
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if errors.Is(err, ErrReplicaSetRequired) {
+			t.Skip("change streams require a replica set")
+		}
+		errors.Check(errors.Wrap(err, "failed to watch"))
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	user := User{
+		ID:   idGen.Generate(),
+		Name: "test",
+	}
+	_, err := userRepository.Create(context.Background(), &user)
+	errors.Check(errors.Wrap(err, "failed to create user"))
+
+	errors.Check(errors.Wrap(<-done, "failed to watch"))
+	c := <-received
+	assert.Equal(t, c.op, "insert")
+	assert.Equal(t, c.user.ID, user.ID)
+	assert.Equal(t, c.user.Name, "test")
+}